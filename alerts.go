@@ -0,0 +1,385 @@
+package main
+
+//Reglas de alerta de los usuarios. Las caídas se detectan con los eventos de
+//Docker, la salud con el monitor de salud, la memoria con el muestreador de
+//métricas y los builds fallidos en BuildRelease. Los avisos salen por la cola de
+//webhooks.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var alertMaxRules = GetEnvInt("ALERT_MAX_RULES", 20)
+
+const (
+	defaultAlertCooldown    = 15 // minutos
+	defaultCrashLoopCount   = 3
+	defaultCrashLoopWindow  = 10 // minutos
+	alertsCollection        = "alertRules"
+	killedContainerGrace    = 30 * time.Second
+	crashWatcherRetryPeriod = 5 * time.Second
+)
+
+var errInvalidAlertRule = errors.New("invalid alert rule")
+
+// alertState guarda en memoria cuándo se disparó cada regla por servicio y las
+// caídas recientes; se pierde al reiniciar la API, lo peor es un aviso repetido
+var alertState = struct {
+	sync.Mutex
+	lastFired map[string]time.Time
+	crashes   map[string][]time.Time
+}{lastFired: map[string]time.Time{}, crashes: map[string][]time.Time{}}
+
+// NewAlertRule valida la petición y completa los valores por defecto
+func NewAlertRule(userID string, req alertRuleRequest) (AlertRule, error) {
+	rule := AlertRule{
+		UserID:          userID,
+		Type:            req.Type,
+		Threshold:       req.Threshold,
+		WindowMinutes:   req.WindowMinutes,
+		CooldownMinutes: defaultAlertCooldown,
+		URL:             req.URL,
+		Format:          req.Format,
+		CreatedAt:       time.Now(),
+	}
+	if req.ContainerName != "" {
+		name, err := ParseServiceName(req.ContainerName)
+		if err != nil {
+			return rule, fmt.Errorf("%w: %v", errInvalidAlertRule, err)
+		}
+		rule.ContainerName = name
+	}
+	if req.CooldownMinutes != nil {
+		rule.CooldownMinutes = *req.CooldownMinutes
+	}
+	if rule.Format == "" {
+		rule.Format = "json"
+	}
+	if err := ValidateWebhookURL(rule.URL); err != nil {
+		return rule, err
+	}
+
+	switch rule.Type {
+	case "memory":
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return rule, fmt.Errorf("%w: memory threshold must be a percentage between 0 and 100", errInvalidAlertRule)
+		}
+	case "crash_loop":
+		if rule.Threshold == 0 {
+			rule.Threshold = defaultCrashLoopCount
+		}
+		if rule.WindowMinutes == 0 {
+			rule.WindowMinutes = defaultCrashLoopWindow
+		}
+		if rule.Threshold < 2 {
+			return rule, fmt.Errorf("%w: crash_loop threshold must be at least 2 crashes", errInvalidAlertRule)
+		}
+	}
+	return rule, nil
+}
+
+func (s *store) CreateAlertRule(rule AlertRule) (*AlertRule, error) {
+	s, end := s.trace("store.CreateAlertRule")
+	defer end()
+	collection := s.database.Collection(alertsCollection)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"userId": rule.UserID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count alert rules: %w", err)
+	}
+	if alertMaxRules > 0 && count >= int64(alertMaxRules) {
+		return nil, fmt.Errorf("%w: at most %d alert rules per user", errInvalidAlertRule, alertMaxRules)
+	}
+
+	rule.ID = primitive.NewObjectID()
+	rule.Secret = newWebhookSecret()
+	if _, err := collection.InsertOne(ctx, rule); err != nil {
+		return nil, fmt.Errorf("failed to save alert rule: %w", err)
+	}
+	return &rule, nil
+}
+
+func (s *store) GetAlertRules(userID string) ([]AlertRule, error) {
+	s, end := s.trace("store.GetAlertRules")
+	defer end()
+	return s.findAlertRules(bson.M{"userId": userID})
+}
+
+func (s *store) findAlertRules(filter bson.M) ([]AlertRule, error) {
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cur, err := s.database.Collection(alertsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find alert rules: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	rules := []AlertRule{}
+	if err := cur.All(ctx, &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// DeleteAlertRule devuelve false si la regla no existe o es de otro usuario
+func (s *store) DeleteAlertRule(userID, id string) (bool, error) {
+	s, end := s.trace("store.DeleteAlertRule")
+	defer end()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.database.Collection(alertsCollection).DeleteOne(ctx, bson.M{"_id": oid, "userId": userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return false, nil
+	}
+
+	if err := s.CancelDeliveries(userID, "alert", id); err != nil {
+		webhookLog.ErrorContext(ctx, "error cancelando envíos pendientes", "sourceId", id, "error", err)
+	}
+	return true, nil
+}
+
+// FireAlerts dispara las reglas de tipo alertType del servicio. value es lo que se
+// compara con el umbral en las reglas de memoria.
+func (s *store) FireAlerts(userID string, name ServiceName, alertType string, value float64, message string, details map[string]any) {
+	s, end := s.trace("store.FireAlerts")
+	defer end()
+
+	rules, err := s.findAlertRules(bson.M{
+		"userId":        userID,
+		"type":          alertType,
+		"containerName": bson.M{"$in": bson.A{nil, name}},
+	})
+	if err != nil {
+		alertLog.ErrorContext(s.baseContext(), "error leyendo reglas", "error", err)
+		return
+	}
+	for _, rule := range rules {
+		if rule.Type == "memory" && value < rule.Threshold {
+			continue
+		}
+		s.fireAlert(rule, name, message, details)
+	}
+}
+
+// fireAlert encola el aviso de una regla, salvo que esté en su período de espera
+func (s *store) fireAlert(rule AlertRule, name ServiceName, message string, details map[string]any) {
+	key := rule.ID.Hex() + "/" + name.String()
+	now := time.Now()
+
+	alertState.Lock()
+	last, ok := alertState.lastFired[key]
+	if ok && now.Sub(last) < time.Duration(rule.CooldownMinutes)*time.Minute {
+		alertState.Unlock()
+		return
+	}
+	alertState.lastFired[key] = now
+	alertState.Unlock()
+
+	alert := Alert{
+		RuleID:        rule.ID.Hex(),
+		Type:          rule.Type,
+		ContainerName: name,
+		Message:       message,
+		Details:       details,
+		FiredAt:       now,
+	}
+	target := WebhookTarget{
+		UserID:   rule.UserID,
+		Source:   "alert",
+		SourceID: rule.ID.Hex(),
+		URL:      rule.URL,
+		Format:   rule.Format,
+		Secret:   rule.Secret,
+	}
+	text := fmt.Sprintf(":rotating_light: *%s* en `%s`: %s", rule.Type, name, message)
+	if err := s.EnqueueWebhook(target, "alert."+rule.Type, alert, text); err != nil {
+		alertLog.ErrorContext(s.baseContext(), "error encolando alerta", "ruleId", alert.RuleID, "error", err)
+		return
+	}
+	alertLog.InfoContext(s.baseContext(), "alerta disparada", "ruleId", alert.RuleID, "type", rule.Type, "containerName", name)
+}
+
+// recordCrash registra la caída y dispara crash y, si corresponde, crash_loop
+func (s *store) recordCrash(rec *ContainerRecord, exitCode int, oomKilled bool) {
+	s, end := s.trace("store.recordCrash")
+	defer end()
+
+	details := map[string]any{"exitCode": exitCode, "oomKilled": oomKilled}
+	message := fmt.Sprintf("el contenedor terminó con código %d", exitCode)
+	if oomKilled {
+		message = "el contenedor se quedó sin memoria (OOM)"
+	}
+	s.FireAlerts(rec.UserID, rec.ContainerName, "crash", 0, message, details)
+
+	rules, err := s.findAlertRules(bson.M{
+		"userId":        rec.UserID,
+		"type":          "crash_loop",
+		"containerName": bson.M{"$in": bson.A{nil, rec.ContainerName}},
+	})
+	if err != nil {
+		alertLog.ErrorContext(s.baseContext(), "error leyendo reglas", "error", err)
+		return
+	}
+
+	key := rec.UserID + "/" + rec.ContainerName.String()
+	now := time.Now()
+	alertState.Lock()
+	// Se guardan las caídas de la última hora, más que cualquier ventana razonable
+	crashes := []time.Time{now}
+	for _, t := range alertState.crashes[key] {
+		if now.Sub(t) < time.Hour {
+			crashes = append(crashes, t)
+		}
+	}
+	alertState.crashes[key] = crashes
+	alertState.Unlock()
+
+	for _, rule := range rules {
+		window := time.Duration(rule.WindowMinutes) * time.Minute
+		count := 0
+		for _, t := range crashes {
+			if now.Sub(t) <= window {
+				count++
+			}
+		}
+		if float64(count) >= rule.Threshold {
+			s.fireAlert(rule, rec.ContainerName, fmt.Sprintf("%d caídas en %d minutos", count, rule.WindowMinutes),
+				map[string]any{"crashes": count, "windowMinutes": rule.WindowMinutes, "exitCode": exitCode})
+		}
+	}
+}
+
+// checkMemoryAlerts compara el uso de memoria de cada servicio con las reglas de
+// memoria de su dueño; se llama con cada pasada del muestreador de métricas
+func (s *store) checkMemoryAlerts(usage map[*ContainerRecord]float64) {
+	if len(usage) == 0 {
+		return
+	}
+	rules, err := s.findAlertRules(bson.M{"type": "memory"})
+	if err != nil {
+		alertLog.ErrorContext(s.baseContext(), "error leyendo reglas", "error", err)
+		return
+	}
+
+	for rec, percent := range usage {
+		for _, rule := range rules {
+			if rule.UserID != rec.UserID || (rule.ContainerName != "" && rule.ContainerName != rec.ContainerName) {
+				continue
+			}
+			if percent < rule.Threshold {
+				continue
+			}
+			s.fireAlert(rule, rec.ContainerName,
+				fmt.Sprintf("memoria al %.1f%% del límite (umbral %.0f%%)", percent, rule.Threshold),
+				map[string]any{"memoryPercent": percent, "threshold": rule.Threshold})
+		}
+	}
+}
+
+// watchCrashes sigue los eventos de Docker de los contenedores de servicio. Una
+// salida ("die") que no vino precedida de un "kill" de la plataforma (stop,
+// redeploy, eliminación) es una caída.
+func watchCrashes(ctx context.Context, s *store) error {
+	msgs, errs := s.client.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("label", "plataforma.path"),
+		),
+	})
+
+	killed := map[string]time.Time{}
+	oom := map[string]bool{}
+	for {
+		select {
+		case msg := <-msgs:
+			name := msg.Actor.Attributes["name"]
+			switch msg.Action {
+			case events.ActionKill:
+				killed[name] = time.Now()
+			case events.ActionOOM:
+				oom[name] = true
+			case events.ActionDie:
+				wasKilled := time.Since(killed[name]) < killedContainerGrace
+				wasOOM := oom[name]
+				delete(killed, name)
+				delete(oom, name)
+				if wasKilled && !wasOOM {
+					continue
+				}
+				exitCode, _ := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+				handleCrash(s, name, exitCode, wasOOM)
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+func handleCrash(s *store, dockerName string, exitCode int, oomKilled bool) {
+	s, span := startSpan(s, "alerts.crash")
+	defer span.End()
+
+	rec, err := s.GetContainerByDockerName(dockerName)
+	if err != nil {
+		alertLog.ErrorContext(s.baseContext(), "error leyendo contenedor", "dockerName", dockerName, "error", err)
+		return
+	}
+	// Sin registro es un contenedor temporal de un redeploy; detenido, lo paró el usuario
+	if rec == nil || !rec.Status {
+		return
+	}
+	alertLog.WarnContext(s.baseContext(), "caída de contenedor", "containerName", rec.ContainerName, "exitCode", exitCode, "oomKilled", oomKilled)
+	reason := ""
+	if oomKilled {
+		reason = "out of memory"
+	}
+	s.recordHistory(ContainerUpdate{
+		UserID:        rec.UserID,
+		ContainerName: rec.ContainerName,
+		Event:         HistoryCrashed,
+		Actor:         actorDocker,
+		Reason:        reason,
+		ExitCode:      &exitCode,
+		Release:       rec.Release,
+	})
+	s.recordCrash(rec, exitCode, oomKilled)
+}
+
+func StartCrashWatcher(ctx context.Context, s *store) {
+	for {
+		err := watchCrashes(ctx, s)
+		if ctx.Err() != nil {
+			alertLog.Info("bucle detenido")
+			return
+		}
+		alertLog.Warn("se cortaron los eventos de Docker, reconectando", "error", err)
+		select {
+		case <-time.After(crashWatcherRetryPeriod):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
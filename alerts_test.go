@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewAlertRule(t *testing.T) {
+	zero := 0
+	thirty := 30
+
+	tests := []struct {
+		name    string
+		req     alertRuleRequest
+		want    AlertRule // solo se comparan los campos que completa NewAlertRule
+		wantErr error
+	}{
+		{
+			name: "defaults",
+			req:  alertRuleRequest{Type: "crash", URL: "https://hooks.example.com/x"},
+			want: AlertRule{Type: "crash", CooldownMinutes: defaultAlertCooldown, Format: "json"},
+		},
+		{
+			name: "service and slack format",
+			req:  alertRuleRequest{ContainerName: "api-users", Type: "unhealthy", URL: "https://hooks.slack.com/x", Format: "slack"},
+			want: AlertRule{ContainerName: "api-users", Type: "unhealthy", CooldownMinutes: defaultAlertCooldown, Format: "slack"},
+		},
+		{
+			name: "explicit zero cooldown",
+			req:  alertRuleRequest{Type: "crash", URL: "https://hooks.example.com/x", CooldownMinutes: &zero},
+			want: AlertRule{Type: "crash", CooldownMinutes: 0, Format: "json"},
+		},
+		{
+			name: "custom cooldown",
+			req:  alertRuleRequest{Type: "build_failed", URL: "https://hooks.example.com/x", CooldownMinutes: &thirty},
+			want: AlertRule{Type: "build_failed", CooldownMinutes: 30, Format: "json"},
+		},
+		{
+			name: "crash loop defaults",
+			req:  alertRuleRequest{Type: "crash_loop", URL: "https://hooks.example.com/x"},
+			want: AlertRule{Type: "crash_loop", Threshold: defaultCrashLoopCount, WindowMinutes: defaultCrashLoopWindow, CooldownMinutes: defaultAlertCooldown, Format: "json"},
+		},
+		{
+			name:    "crash loop with a single crash",
+			req:     alertRuleRequest{Type: "crash_loop", Threshold: 1, URL: "https://hooks.example.com/x"},
+			wantErr: errInvalidAlertRule,
+		},
+		{
+			name: "memory threshold",
+			req:  alertRuleRequest{Type: "memory", Threshold: 90, URL: "https://hooks.example.com/x"},
+			want: AlertRule{Type: "memory", Threshold: 90, CooldownMinutes: defaultAlertCooldown, Format: "json"},
+		},
+		{
+			name:    "memory without threshold",
+			req:     alertRuleRequest{Type: "memory", URL: "https://hooks.example.com/x"},
+			wantErr: errInvalidAlertRule,
+		},
+		{
+			name:    "memory over 100",
+			req:     alertRuleRequest{Type: "memory", Threshold: 150, URL: "https://hooks.example.com/x"},
+			wantErr: errInvalidAlertRule,
+		},
+		{
+			name:    "invalid service name",
+			req:     alertRuleRequest{ContainerName: "Bad_Name", Type: "crash", URL: "https://hooks.example.com/x"},
+			wantErr: errInvalidAlertRule,
+		},
+		{
+			name:    "invalid url",
+			req:     alertRuleRequest{Type: "crash", URL: "ftp://example.com"},
+			wantErr: errInvalidWebhook,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAlertRule("user-1", tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("NewAlertRule() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.UserID != "user-1" || got.URL != tt.req.URL || got.CreatedAt.IsZero() {
+				t.Errorf("NewAlertRule() = %+v, missing user, url or createdAt", got)
+			}
+			if got.ContainerName != tt.want.ContainerName || got.Type != tt.want.Type ||
+				got.Threshold != tt.want.Threshold || got.WindowMinutes != tt.want.WindowMinutes ||
+				got.CooldownMinutes != tt.want.CooldownMinutes || got.Format != tt.want.Format {
+				t.Errorf("NewAlertRule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,32 +5,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"time"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey contextKey = "userID"
+	roleKey   contextKey = "role"
+	emailKey  contextKey = "email"
+)
+
+var adminRole = GetEnv("ADMIN_ROLE", "admin")
 
 func WithJWTAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := GetTokenFromRequest(r)
 
-		jwtResp, err := ValidateJWT(tokenString)
+		jwtResp, err := ValidateJWT(r.Context(), tokenString)
 		if err != nil {
-			log.Printf("failed to validate token %v", err)
+			authLog.WarnContext(r.Context(), "failed to validate token", "error", err)
 			PermissionDenied(w)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), userIDKey, jwtResp.User.Sub)
+		ctx = context.WithValue(ctx, roleKey, jwtResp.User.Role)
+		ctx = context.WithValue(ctx, emailKey, jwtResp.User.Email)
+		authLog.DebugContext(ctx, "authenticated", "userId", jwtResp.User.Sub, "role", jwtResp.User.Role)
 
 		handlerFunc(w, r.WithContext(ctx))
 
 	}
 }
 
+// WithAdminAuth es WithJWTAuth pero solo deja pasar a usuarios con el rol ADMIN_ROLE
+func WithAdminAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r.Context()) {
+			PermissionDenied(w)
+			return
+		}
+		handlerFunc(w, r)
+	})
+}
+
 func PermissionDenied(w http.ResponseWriter) {
 	WriteError(w, http.StatusForbidden, "permission denied")
 }
@@ -53,11 +73,16 @@ func GetTokenFromRequest(r *http.Request) string {
 	return ""
 }
 
-func ValidateJWT(tokenString string) (*verifyTokenResponse, error) {
+func ValidateJWT(ctx context.Context, tokenString string) (*verifyTokenResponse, error) {
+	started := time.Now()
+	defer func() { authDuration.Observe(time.Since(started).Seconds()) }()
+
+	ctx, span := tracer.Start(ctx, "auth.ValidateJWT")
+	defer span.End()
+
 	// Create request
-	req, err := http.NewRequest("GET", url+"/auth/"+proyectID+"/verify-token", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"/auth/"+proyectID+"/verify-token", nil)
 	if err != nil {
-		fmt.Println("Error creating request:", err)
 		return nil, err
 	}
 
@@ -65,24 +90,27 @@ func ValidateJWT(tokenString string) (*verifyTokenResponse, error) {
 	req.Header.Set("Authorization", "Bearer "+tokenString)
 
 	// Send request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := tracingClient.Do(req)
 	if err != nil {
-		fmt.Println("Error sending request:", err)
+		authFailures.WithLabelValues("request").Inc()
+		authLog.ErrorContext(ctx, "error sending request", "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// Read response
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Println("Status:", resp.Status)
-	fmt.Println("Response:", string(body))
+	// El cuerpo trae los datos del usuario; solo se registra el código
+	authLog.DebugContext(ctx, "token verified", "status", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
+		authFailures.WithLabelValues("status").Inc()
 		return nil, fmt.Errorf("invalid status code: %d", resp.StatusCode)
 	}
 
 	var jwtResp verifyTokenResponse
 	if err := json.Unmarshal(body, &jwtResp); err != nil {
+		authFailures.WithLabelValues("decode").Inc()
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
@@ -96,3 +124,13 @@ func GetUserIDFromContext(ctx context.Context) (string, error) {
 	}
 	return userID, nil
 }
+
+func IsAdmin(ctx context.Context) bool {
+	role, _ := ctx.Value(roleKey).(string)
+	return role != "" && role == adminRole
+}
+
+func GetEmailFromContext(ctx context.Context) string {
+	email, _ := ctx.Value(emailKey).(string)
+	return email
+}
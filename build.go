@@ -0,0 +1,324 @@
+package main
+
+//Preparación de los archivos que forman el contexto de un build
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+var errInvalidBuildInput = errors.New("invalid build input")
+
+// commitLabel guarda en la imagen el commit de Git del que se construyó
+const commitLabel = "plataforma.commit"
+
+// workspaceRoot es la carpeta bajo la que se preparan todos los contextos de build
+const workspaceRoot = "./workspace"
+
+// newBuildWorkspace crea una carpeta temporal propia del build; quien la pide la
+// elimina al terminar. Así dos builds del mismo servicio no se pisan los archivos.
+func newBuildWorkspace(prefix string) (string, error) {
+	if err := os.MkdirAll(workspaceRoot, os.ModePerm); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(workspaceRoot, prefix)
+}
+
+// Prefijos de las carpetas que crea la plataforma dentro de workspaceRoot; "svc-"
+// son las carpetas fijas por servicio que se usaban antes de los builds temporales
+var workspacePrefixes = []string{"build-", "git-", "svc-"}
+
+// CleanWorkspaces elimina los workspaces abandonados por builds que no terminaron
+// (por ejemplo, si la API se reinició a mitad de un build). Se llama al arrancar,
+// antes de aceptar peticiones, así que ningún build está en curso.
+func CleanWorkspaces() {
+	entries, err := os.ReadDir(workspaceRoot)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			buildLog.Error("error leyendo workspaces", "dir", workspaceRoot, "error", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !hasWorkspacePrefix(entry.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(workspaceRoot, entry.Name())); err != nil {
+			buildLog.Error("error eliminando workspace", "workspace", entry.Name(), "error", err)
+			continue
+		}
+		buildLog.Info("workspace abandonado eliminado", "workspace", entry.Name())
+	}
+}
+
+func hasWorkspacePrefix(name string) bool {
+	for _, prefix := range workspacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// contextModTime es la fecha que llevan todas las entradas del contexto, para que
+// el mismo código fuente produzca siempre el mismo tar y reutilice la caché de build
+var contextModTime = time.Unix(0, 0).UTC()
+
+// writeContextTar escribe dir como un tar en w, sin la carpeta .git ni lo que
+// excluya su .dockerignore. Conserva permisos, carpetas y enlaces simbólicos (sin
+// seguirlos) y respeta el tamaño máximo del contexto de la política de builds.
+func writeContextTar(w io.Writer, dir string) error {
+	excludes, err := readDockerignore(dir)
+	if err != nil {
+		return fmt.Errorf("%w: .dockerignore inválido: %v", errInvalidBuildInput, err)
+	}
+
+	tw := tar.NewWriter(w)
+	var contextSize int64
+
+	// WalkDir recorre en orden léxico, así que el orden de las entradas es estable
+	err = filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == dir {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if excludes != nil && !alwaysInContext[relPath] {
+			skip, err := excludes.MatchesOrParentMatches(relPath)
+			if err != nil {
+				return err
+			}
+			if skip {
+				// Sin excepciones (!patrón) ningún archivo de la carpeta puede volver a entrar
+				if d.IsDir() && !excludes.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Name:    relPath,
+			Mode:    int64(fi.Mode().Perm()),
+			ModTime: contextModTime,
+			Format:  tar.FormatPAX,
+		}
+
+		switch {
+		case fi.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = fi.Size()
+		case fi.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case fi.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+		default:
+			// Sockets, dispositivos y pipes no tienen sentido en un contexto de build
+			return nil
+		}
+
+		contextSize += hdr.Size
+		if buildPolicy.MaxContextSize > 0 && contextSize > buildPolicy.MaxContextSize {
+			return fmt.Errorf("%w: el contexto supera %d MB", errInvalidBuildInput, buildPolicy.MaxContextSize>>20)
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Si el archivo crece mientras se copia, solo se envía el tamaño declarado
+		_, err = io.CopyN(tw, f, hdr.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// Docker siempre envía el Dockerfile y el .dockerignore aunque estén excluidos
+var alwaysInContext = map[string]bool{"Dockerfile": true, ".dockerignore": true}
+
+// readDockerignore devuelve los patrones del .dockerignore de dir, o nil si no hay
+func readDockerignore(dir string) (*patternmatcher.PatternMatcher, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return patternmatcher.New(patterns)
+}
+
+type BuildOptions struct {
+	BuildArgs map[string]*string
+	Target    string
+	Labels    map[string]string
+}
+
+// prepareBuildWorkspace escribe en workspaceDir el app.py subido, el server.py base
+// y el Dockerfile (el subido en el campo "dockerfile" o el de ./files por defecto).
+// Los errores envueltos con errInvalidBuildInput son culpa del cliente.
+func prepareBuildWorkspace(r *http.Request, workspaceDir string) (BuildOptions, error) {
+	var opts BuildOptions
+
+	appFileHeader, ok := r.MultipartForm.File["app"]
+	if !ok || len(appFileHeader) == 0 {
+		return opts, fmt.Errorf("%w: archivo app.py es obligatorio", errInvalidBuildInput)
+	}
+	if err := saveUploadedFile(appFileHeader[0], filepath.Join(workspaceDir, "app.py")); err != nil {
+		return opts, fmt.Errorf("no se pudo guardar app.py: %v", err)
+	}
+
+	if err := copyFile("./files/server.py", filepath.Join(workspaceDir, "server.py")); err != nil {
+		return opts, fmt.Errorf("no se pudo copiar server.py: %v", err)
+	}
+
+	if dockerfileHeader, ok := r.MultipartForm.File["dockerfile"]; ok && len(dockerfileHeader) > 0 {
+		if err := saveUploadedFile(dockerfileHeader[0], filepath.Join(workspaceDir, "Dockerfile")); err != nil {
+			return opts, fmt.Errorf("no se pudo guardar el Dockerfile: %v", err)
+		}
+	} else if err := copyFile("./files/Dockerfile", filepath.Join(workspaceDir, "Dockerfile")); err != nil {
+		return opts, fmt.Errorf("no se pudo usar Dockerfile por defecto: %v", err)
+	}
+
+	if raw := r.FormValue("buildArgs"); raw != "" {
+		var args map[string]string
+		if err := json.Unmarshal([]byte(raw), &args); err != nil {
+			return opts, fmt.Errorf("%w: buildArgs debe ser un objeto JSON de strings", errInvalidBuildInput)
+		}
+		opts.BuildArgs = make(map[string]*string, len(args))
+		for k, v := range args {
+			opts.BuildArgs[k] = &v
+		}
+	}
+	opts.Target = r.FormValue("target")
+
+	return opts, nil
+}
+
+func saveUploadedFile(header *multipart.FileHeader, dst string) error {
+	src, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeFile(dst, src)
+}
+
+func copyFile(srcPath, dst string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeFile(dst, src)
+}
+
+// writeFile crea dst sin seguir enlaces simbólicos y sin pisar nada: si en su
+// lugar hay un enlace (p. ej. traído en un repositorio) falla en vez de escribir
+// donde apunte el enlace
+func writeFile(dst string, src io.Reader) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// checkContextFile verifica, sin seguir enlaces, que path sea un archivo regular
+func checkContextFile(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s debe ser un archivo regular, no un enlace simbólico", errInvalidBuildInput, filepath.Base(path))
+	}
+	return nil
+}
+
+// readContextFile lee un archivo del contexto sin seguir enlaces simbólicos
+func readContextFile(path string) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ELOOP) {
+			return nil, fmt.Errorf("%w: %s no puede ser un enlace simbólico", errInvalidBuildInput, filepath.Base(path))
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %s debe ser un archivo regular", errInvalidBuildInput, filepath.Base(path))
+	}
+	return io.ReadAll(f)
+}
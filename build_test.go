@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTar devuelve las entradas del tar por nombre
+func readTar(t *testing.T, data []byte) map[string]*tar.Header {
+	t.Helper()
+	entries := map[string]*tar.Header{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = hdr
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteContextTarEntries(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret")
+	writeTestFile(t, outside, "no debe salir")
+
+	writeTestFile(t, filepath.Join(dir, "Dockerfile"), "FROM python:3.12\n")
+	writeTestFile(t, filepath.Join(dir, "app.py"), "print('hola')\n")
+	writeTestFile(t, filepath.Join(dir, "..data"), "nombre raro pero válido")
+	writeTestFile(t, filepath.Join(dir, "src", "mod.py"), "x = 1\n")
+	writeTestFile(t, filepath.Join(dir, ".git", "config"), "[core]\n")
+	writeTestFile(t, filepath.Join(dir, "build", "out.bin"), "ignorado")
+	writeTestFile(t, filepath.Join(dir, ".dockerignore"), "build\nDockerfile\n")
+	if err := os.Symlink("../../"+filepath.Base(outside), filepath.Join(dir, "rel-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "abs-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeContextTar(&buf, dir); err != nil {
+		t.Fatal(err)
+	}
+	entries := readTar(t, buf.Bytes())
+
+	for name, hdr := range entries {
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+			t.Errorf("entry %q has an absolute path", name)
+		}
+		for _, part := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+			if part == ".." {
+				t.Errorf("entry %q escapes the context", name)
+			}
+		}
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 && strings.Contains(name, "link") {
+			t.Errorf("symlink %q was followed", name)
+		}
+	}
+
+	for _, name := range []string{"Dockerfile", ".dockerignore", "app.py", "..data", "src/", "src/mod.py"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("missing entry %q", name)
+		}
+	}
+	for _, name := range []string{".git/", ".git/config", "build/", "build/out.bin"} {
+		if _, ok := entries[name]; ok {
+			t.Errorf("entry %q should have been excluded", name)
+		}
+	}
+
+	// Los enlaces viajan como enlaces; Docker los resuelve dentro del contexto
+	for _, name := range []string{"rel-link", "abs-link"} {
+		hdr, ok := entries[name]
+		if !ok {
+			t.Errorf("missing entry %q", name)
+			continue
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			t.Errorf("entry %q has type %c, want symlink", name, hdr.Typeflag)
+		}
+	}
+}
+
+func TestWriteContextTarDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "Dockerfile"), "FROM python:3.12\n")
+	writeTestFile(t, filepath.Join(dir, "b.py"), "b")
+	writeTestFile(t, filepath.Join(dir, "a.py"), "a")
+
+	var first, second bytes.Buffer
+	if err := writeContextTar(&first, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a.py"), contextModTime.AddDate(1, 0, 0), contextModTime.AddDate(1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContextTar(&second, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("the same sources produced different tars")
+	}
+}
+
+func TestWriteContextTarMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "big"), strings.Repeat("x", 2048))
+
+	saved := buildPolicy.MaxContextSize
+	buildPolicy.MaxContextSize = 1024
+	defer func() { buildPolicy.MaxContextSize = saved }()
+
+	err := writeContextTar(io.Discard, dir)
+	if !errors.Is(err, errInvalidBuildInput) {
+		t.Fatalf("writeContextTar() = %v, want errInvalidBuildInput", err)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+//Reglas que debe cumplir un Dockerfile subido por el usuario antes de construirlo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type BuildPolicy struct {
+	AllowedBaseImages []string      // patrones tipo "python:*"; vacío = cualquier imagen
+	ForbidRootUser    bool          // prohíbe USER root en la etapa final
+	MaxBuildTime      time.Duration // tiempo máximo de un docker build
+	MaxContextSize    int64         // tamaño máximo del contexto en bytes
+}
+
+var buildPolicy = BuildPolicy{
+	AllowedBaseImages: GetEnvList("BUILD_ALLOWED_BASE_IMAGES", nil),
+	ForbidRootUser:    GetEnv("BUILD_FORBID_ROOT_USER", "true") == "true",
+	MaxBuildTime:      GetEnvDuration("BUILD_MAX_TIME", 10*time.Minute),
+	MaxContextSize:    int64(GetEnvInt("BUILD_MAX_CONTEXT_MB", 50)) << 20,
+}
+
+type dockerfileStage struct {
+	name   string
+	from   string
+	user   string
+	labels []string // claves de las instrucciones LABEL
+}
+
+// Prefijos de labels que solo pone la plataforma. Docker copia las labels de la
+// imagen al contenedor, así que con traefik.* una imagen podría declarar sus propias
+// rutas y quedarse con las de otro usuario o con /api.
+var reservedLabelPrefixes = []string{"traefik.", "plataforma."}
+
+func isReservedLabel(key string) bool {
+	key = strings.ToLower(key)
+	for _, prefix := range reservedLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImageLabels rechaza una imagen con labels reservadas. plataforma.commit se
+// acepta porque el build siempre la pisa con su propio valor.
+func checkImageLabels(labels map[string]string) error {
+	for key := range labels {
+		if key != commitLabel && isReservedLabel(key) {
+			return fmt.Errorf("image label %q is reserved for the platform", key)
+		}
+	}
+	return nil
+}
+
+// CheckDockerfile valida las imágenes base y el usuario de la etapa final.
+// target es la etapa a construir; si está vacío se usa la última.
+func (p BuildPolicy) CheckDockerfile(content []byte, target string) error {
+	stages, err := parseDockerfileStages(content)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]int)
+	for i, st := range stages {
+		if _, isStage := byName[strings.ToLower(st.from)]; !isStage {
+			if err := p.checkBaseImage(st.from); err != nil {
+				return err
+			}
+		}
+		for _, key := range st.labels {
+			if strings.Contains(key, "$") {
+				return fmt.Errorf("LABEL %q uses build args and cannot be verified", key)
+			}
+			if isReservedLabel(key) {
+				return fmt.Errorf("LABEL %q is reserved for the platform", key)
+			}
+		}
+		if st.name != "" {
+			byName[strings.ToLower(st.name)] = i
+		}
+	}
+
+	final := len(stages) - 1
+	if target != "" {
+		i, ok := byName[strings.ToLower(target)]
+		if !ok {
+			return fmt.Errorf("target stage %q not found in Dockerfile", target)
+		}
+		final = i
+	}
+
+	if p.ForbidRootUser {
+		user := effectiveUser(stages, byName, final)
+		if strings.Contains(user, "$") {
+			return fmt.Errorf("USER %q uses build args and cannot be verified", user)
+		}
+		if isRootUser(user) {
+			return fmt.Errorf("USER root is not allowed in the final stage")
+		}
+	}
+
+	return nil
+}
+
+func (p BuildPolicy) checkBaseImage(ref string) error {
+	if len(p.AllowedBaseImages) == 0 || ref == "scratch" {
+		return nil
+	}
+	if strings.Contains(ref, "$") {
+		return fmt.Errorf("base image %q uses build args and cannot be verified", ref)
+	}
+	for _, pattern := range p.AllowedBaseImages {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("base image %q is not allowed", ref)
+}
+
+// dockerfileRuntime devuelve la imagen base externa de la que parte la etapa final
+func dockerfileRuntime(content []byte, target string) string {
+	stages, err := parseDockerfileStages(content)
+	if err != nil {
+		return ""
+	}
+
+	byName := make(map[string]int)
+	for i, st := range stages {
+		if st.name != "" {
+			byName[strings.ToLower(st.name)] = i
+		}
+	}
+
+	i := len(stages) - 1
+	if target != "" {
+		if t, ok := byName[strings.ToLower(target)]; ok {
+			i = t
+		}
+	}
+	for seen := 0; seen <= len(stages); seen++ {
+		parent, ok := byName[strings.ToLower(stages[i].from)]
+		if !ok || parent >= i {
+			break
+		}
+		i = parent
+	}
+
+	return stages[i].from
+}
+
+// effectiveUser resuelve el USER de una etapa siguiendo la cadena de etapas padre.
+func effectiveUser(stages []dockerfileStage, byName map[string]int, i int) string {
+	for seen := 0; seen <= len(stages); seen++ {
+		st := stages[i]
+		if st.user != "" {
+			return st.user
+		}
+		parent, ok := byName[strings.ToLower(st.from)]
+		if !ok || parent >= i {
+			return ""
+		}
+		i = parent
+	}
+	return ""
+}
+
+func isRootUser(user string) bool {
+	u, _, _ := strings.Cut(user, ":")
+	if uid, err := strconv.Atoi(u); err == nil {
+		return uid == 0
+	}
+	return u == "root"
+}
+
+// parseDockerfileStages extrae FROM/AS/USER de cada etapa, respetando
+// comentarios y continuaciones de línea con "\".
+func parseDockerfileStages(content []byte) ([]dockerfileStage, error) {
+	var stages []dockerfileStage
+	var logical strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			logical.WriteString(strings.TrimSuffix(line, "\\"))
+			logical.WriteString(" ")
+			continue
+		}
+		logical.WriteString(line)
+		instruction := strings.TrimSpace(logical.String())
+		fields := strings.Fields(instruction)
+		logical.Reset()
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			args := fields[1:]
+			for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+				args = args[1:]
+			}
+			if len(args) == 0 {
+				return nil, fmt.Errorf("FROM without image")
+			}
+			st := dockerfileStage{from: args[0]}
+			if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+				st.name = args[2]
+			}
+			stages = append(stages, st)
+		case "LABEL":
+			if len(stages) == 0 {
+				return nil, fmt.Errorf("LABEL before FROM")
+			}
+			st := &stages[len(stages)-1]
+			st.labels = append(st.labels, labelKeys(instruction[len(fields[0]):])...)
+		case "USER":
+			if len(stages) == 0 {
+				return nil, fmt.Errorf("USER before FROM")
+			}
+			if len(fields) > 1 {
+				stages[len(stages)-1].user = fields[1]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("Dockerfile has no FROM instruction")
+	}
+
+	return stages, nil
+}
+
+// labelKeys devuelve las claves de los argumentos de un LABEL, en la forma
+// "clave=valor ..." o en la antigua "clave valor"
+func labelKeys(args string) []string {
+	var keys []string
+	for i, token := range splitQuoted(args) {
+		key, _, found := strings.Cut(token, "=")
+		if !found {
+			// Forma antigua: la primera palabra es la clave y el resto el valor
+			if i == 0 {
+				keys = append(keys, unquote(token))
+			}
+			break
+		}
+		keys = append(keys, unquote(key))
+	}
+	return keys
+}
+
+// splitQuoted separa por espacios respetando comillas simples y dobles
+func splitQuoted(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	var quote rune
+	inToken := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inToken = true
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if inToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inToken = false
+			}
+		default:
+			inToken = true
+			cur.WriteRune(r)
+		}
+	}
+	if inToken {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	return strings.NewReplacer(`"`, "", "'", "").Replace(s)
+}
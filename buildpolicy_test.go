@@ -0,0 +1,209 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckDockerfile(t *testing.T) {
+	policy := BuildPolicy{
+		AllowedBaseImages: []string{"python:*", "golang:*", "gcr.io/distroless/*"},
+		ForbidRootUser:    true,
+	}
+
+	tests := []struct {
+		name       string
+		dockerfile string
+		target     string
+		wantErr    string // vacío si debe pasar
+	}{
+		{
+			name:       "allowed base and user",
+			dockerfile: "FROM python:3.12\nUSER app\n",
+		},
+		{
+			name:       "base image not allowed",
+			dockerfile: "FROM alpine:3\nUSER app\n",
+			wantErr:    "not allowed",
+		},
+		{
+			name:       "base image with build arg",
+			dockerfile: "ARG IMG=python:3.12\nFROM $IMG\nUSER app\n",
+			wantErr:    "cannot be verified",
+		},
+		{
+			name:       "root user",
+			dockerfile: "FROM python:3.12\nUSER root\n",
+			wantErr:    "USER root",
+		},
+		{
+			name:       "root uid with group",
+			dockerfile: "FROM python:3.12\nUSER 0:0\n",
+			wantErr:    "USER root",
+		},
+		{
+			name:       "root uid with leading zeros",
+			dockerfile: "FROM python:3.12\nUSER 000\n",
+			wantErr:    "USER root",
+		},
+		{
+			name:       "user from build arg",
+			dockerfile: "FROM python:3.12\nARG RUN_AS=root\nUSER $RUN_AS\n",
+			wantErr:    "cannot be verified",
+		},
+		{
+			name:       "user from build arg with braces",
+			dockerfile: "FROM python:3.12\nUSER ${RUN_AS}:app\n",
+			wantErr:    "cannot be verified",
+		},
+		{
+			name:       "multi-stage root only in builder",
+			dockerfile: "FROM golang:1.23 AS build\nUSER root\nRUN go build\nFROM gcr.io/distroless/static\nUSER 65532\n",
+		},
+		{
+			name:       "multi-stage final inherits root from stage",
+			dockerfile: "FROM python:3.12 AS base\nUSER root\nFROM base\nRUN echo hi\n",
+			wantErr:    "USER root",
+		},
+		{
+			name:       "multi-stage final overrides inherited root",
+			dockerfile: "FROM python:3.12 AS base\nUSER root\nFROM base\nUSER app\n",
+		},
+		{
+			name:       "stage reference is not a base image",
+			dockerfile: "FROM python:3.12 AS Base\nUSER app\nFROM base\n",
+		},
+		{
+			name:       "target stage with root",
+			dockerfile: "FROM python:3.12 AS dev\nUSER root\nFROM python:3.12 AS prod\nUSER app\n",
+			target:     "dev",
+			wantErr:    "USER root",
+		},
+		{
+			name:       "target stage without root",
+			dockerfile: "FROM python:3.12 AS dev\nUSER root\nFROM python:3.12 AS prod\nUSER app\n",
+			target:     "prod",
+		},
+		{
+			name:       "unknown target",
+			dockerfile: "FROM python:3.12\nUSER app\n",
+			target:     "missing",
+			wantErr:    "not found",
+		},
+		{
+			name:       "line continuation and comments",
+			dockerfile: "# comentario\nFROM \\\n  python:3.12\n# USER root\nUSER \\\n  app\n",
+		},
+		{
+			name:       "platform flag",
+			dockerfile: "FROM --platform=linux/amd64 python:3.12\nUSER app\n",
+		},
+		{
+			name:       "no from",
+			dockerfile: "USER app\n",
+			wantErr:    "USER before FROM",
+		},
+		{
+			name:       "empty",
+			dockerfile: "",
+			wantErr:    "no FROM",
+		},
+		{
+			name:       "traefik label",
+			dockerfile: "FROM python:3.12\nLABEL traefik.http.routers.x.rule=PathPrefix(`/api`)\nUSER app\n",
+			wantErr:    "reserved",
+		},
+		{
+			name:       "quoted mixed case traefik label",
+			dockerfile: "FROM python:3.12\nLABEL version=1 \"Traefik.enable\"=true\nUSER app\n",
+			wantErr:    "reserved",
+		},
+		{
+			name:       "legacy label syntax",
+			dockerfile: "FROM python:3.12\nLABEL plataforma.commit deadbeef\nUSER app\n",
+			wantErr:    "reserved",
+		},
+		{
+			name:       "label in builder stage",
+			dockerfile: "FROM golang:1.23 AS build\nLABEL plataforma.path=/x\nFROM python:3.12\nUSER app\n",
+			wantErr:    "reserved",
+		},
+		{
+			name:       "label key from build arg",
+			dockerfile: "FROM python:3.12\nLABEL ${PREFIX}.enable=true\nUSER app\n",
+			wantErr:    "cannot be verified",
+		},
+		{
+			name:       "ordinary labels",
+			dockerfile: "FROM python:3.12\nLABEL maintainer=\"Ana <ana@example.com>\" \\\n  description='servicio de prueba'\nUSER app\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.CheckDockerfile([]byte(tt.dockerfile), tt.target)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckDockerfile() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("CheckDockerfile() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDockerfileStages(t *testing.T) {
+	dockerfile := "FROM golang:1.23 AS build\nUSER builder\nFROM build AS test\nFROM gcr.io/distroless/static\nLABEL a=1 b=2\nUSER 65532:65532\n"
+
+	stages, err := parseDockerfileStages([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []dockerfileStage{
+		{name: "build", from: "golang:1.23", user: "builder"},
+		{name: "test", from: "build"},
+		{from: "gcr.io/distroless/static", user: "65532:65532", labels: []string{"a", "b"}},
+	}
+	if len(stages) != len(want) {
+		t.Fatalf("got %d stages, want %d", len(stages), len(want))
+	}
+	for i := range want {
+		got := stages[i]
+		if got.name != want[i].name || got.from != want[i].from || got.user != want[i].user ||
+			strings.Join(got.labels, ",") != strings.Join(want[i].labels, ",") {
+			t.Errorf("stage %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if runtime := dockerfileRuntime([]byte(dockerfile), "test"); runtime != "golang:1.23" {
+		t.Errorf("dockerfileRuntime(test) = %q, want golang:1.23", runtime)
+	}
+}
+
+func TestCheckImageLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		valid  bool
+	}{
+		{"no labels", nil, true},
+		{"ordinary", map[string]string{"maintainer": "x", "org.opencontainers.image.source": "y"}, true},
+		{"commit set by the build", map[string]string{commitLabel: "abc"}, true},
+		{"traefik router", map[string]string{"traefik.http.routers.x.rule": "PathPrefix(`/api`)"}, false},
+		{"traefik enable uppercase", map[string]string{"Traefik.Enable": "true"}, false},
+		{"platform path", map[string]string{"plataforma.path": "/otro/servicio"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkImageLabels(tt.labels)
+			if tt.valid != (err == nil) {
+				t.Fatalf("checkImageLabels(%v) = %v, valid = %v", tt.labels, err, tt.valid)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -16,7 +16,7 @@ type MongoDBClient struct {
 
 func NewMongoDBStorage(uri string, dbName string) (*MongoDBClient, error) {
 	// Configura la URI de conexión a MongoDB
-	clientoptions := options.Client().ApplyURI(uri)
+	clientoptions := options.Client().ApplyURI(uri).SetMonitor(mongoMonitor())
 
 	//Establece parametros de conexion (tiempo de espera)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -25,7 +25,7 @@ func NewMongoDBStorage(uri string, dbName string) (*MongoDBClient, error) {
 	// Conéctate a MongoDB
 	client, err := mongo.Connect(ctx, clientoptions)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	err = client.Ping(ctx, nil)
@@ -33,7 +33,7 @@ func NewMongoDBStorage(uri string, dbName string) (*MongoDBClient, error) {
 		return nil, err
 	}
 
-	log.Println("DB: Successfully connected")
+	slog.Info("connected to MongoDB", "database", dbName)
 	database := client.Database(dbName)
 	return &MongoDBClient{client: client, database: database}, nil
 }
@@ -0,0 +1,147 @@
+package main
+
+//Reemplazo de contenedores sin cortar el servicio (blue/green)
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+var deployTimeout = GetEnvDuration("DEPLOY_TIMEOUT", 60*time.Second)
+
+// Redeploy reemplaza el contenedor dockerName por uno nuevo con containerImage.
+// El nuevo arranca como "<dockerName>-next" en el mismo router de Traefik; solo
+// cuando pasa la sonda de readiness se retira el anterior y se renombra. Si el
+// nuevo falla se elimina y el anterior sigue atendiendo.
+func (s *store) Redeploy(containerImage, dockerName string, spec ContainerSpec, probe *HealthProbe) (ProbeResult, error) {
+	s, end := s.trace("store.Redeploy")
+	defer end()
+	result, err := s.redeploy(containerImage, dockerName, spec, probe)
+	deploys.WithLabelValues("redeploy", outcome(err)).Inc()
+	return result, err
+}
+
+func (s *store) redeploy(containerImage, dockerName string, spec ContainerSpec, probe *HealthProbe) (ProbeResult, error) {
+	ctx := s.baseContext()
+
+	if !strings.Contains(containerImage, ":") {
+		containerImage = containerImage + ":latest"
+	}
+
+	exists, err := s.ContainerExists(dockerName)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	if !exists {
+		if err := s.NewContainer(containerImage, dockerName, spec); err != nil {
+			return ProbeResult{}, err
+		}
+		return s.WaitReady(dockerName, probe)
+	}
+
+	if err := s.ensureImage(containerImage); err != nil {
+		return ProbeResult{}, err
+	}
+
+	// Limpiar restos de un despliegue anterior interrumpido
+	nextName := dockerName + "-next"
+	if err := s.client.ContainerRemove(ctx, nextName, container.RemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+		return ProbeResult{}, fmt.Errorf("failed to remove stale container %s: %w", nextName, err)
+	}
+
+	nextID, err := s.createServiceContainer(ctx, containerImage, dockerName, nextName, spec)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to create new version of %s: %w", dockerName, err)
+	}
+
+	if err := s.client.ContainerStart(ctx, nextID, container.StartOptions{}); err != nil {
+		s.discardContainer(nextID)
+		return ProbeResult{}, fmt.Errorf("failed to start new version of %s: %w", dockerName, err)
+	}
+
+	result, err := s.WaitReady(nextID, probe)
+	if err != nil {
+		s.discardContainer(nextID)
+		return result, fmt.Errorf("new version of %s failed, previous version kept: %w", dockerName, err)
+	}
+
+	// El nuevo ya recibe tráfico; retirar el anterior y tomar su nombre
+	if err := s.StopAndRemoveContainer(dockerName); err != nil {
+		return result, err
+	}
+	if err := s.client.ContainerRename(ctx, nextID, dockerName); err != nil {
+		return result, fmt.Errorf("failed to rename %s: %w", nextName, err)
+	}
+
+	deployLog.InfoContext(s.baseContext(), "contenedor reemplazado", "dockerName", dockerName, "image", containerImage)
+	return result, nil
+}
+
+func (s *store) discardContainer(containerID string) {
+	if err := s.client.ContainerRemove(s.baseContext(), containerID, container.RemoveOptions{Force: true}); err != nil {
+		deployLog.ErrorContext(s.baseContext(), "error eliminando contenedor fallido", "containerId", containerID, "error", err)
+	}
+}
+
+// ServiceSpec arma la configuración de despliegue a partir del registro del
+// contenedor: límites según el plan del usuario, variables de entorno descifradas
+// volúmenes conectados, la red del usuario y el perfil de seguridad.
+func (s *store) ServiceSpec(rec *ContainerRecord) (ContainerSpec, error) {
+	s, end := s.trace("store.ServiceSpec")
+	defer end()
+	plan, err := s.GetPlan(rec.UserID)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	limits, err := plan.Resolve(&rec.Resources)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	env, err := s.ResolveEnv(rec.UserID, rec.ContainerName)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	mounts, err := s.ContainerMounts(rec.UserID, rec.ContainerName)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	networkName, err := s.EnsureTenantNetwork(rec.UserID, rec.ContainerName)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	handle, err := s.EnsureTenant(rec.UserID, "")
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	return ContainerSpec{
+		Name:      rec.ContainerName,
+		Tenant:    handle,
+		Resources: limits,
+		Env:       env,
+		Mounts:    mounts,
+		Network:   networkName,
+		Security:  EffectiveSecurity(rec.Security),
+	}, nil
+}
+
+// RestartWithConfig vuelve a desplegar la imagen actual del servicio con la
+// configuración guardada; se usa cuando cambia algo que Docker fija al crear.
+func (s *store) RestartWithConfig(rec *ContainerRecord) (ProbeResult, error) {
+	s, end := s.trace("store.RestartWithConfig")
+	defer end()
+	info, err := s.client.ContainerInspect(s.baseContext(), rec.DockerName)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to inspect %s: %w", rec.ContainerName, err)
+	}
+
+	spec, err := s.ServiceSpec(rec)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	return s.Redeploy(info.Config.Image, rec.DockerName, spec, rec.Probe)
+}
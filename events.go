@@ -0,0 +1,244 @@
+package main
+
+//Suscripciones a los eventos de la plataforma (builds, despliegues, cambios de
+//estado). Cada evento se encola en la cola de webhooks una vez por suscripción
+//que lo pida, firmado con el secreto de esa suscripción.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var webhookMaxSubscriptions = GetEnvInt("WEBHOOK_MAX_SUBSCRIPTIONS", 10)
+
+const subscriptionsCollection = "webhooks"
+
+const (
+	EventImageBuilt      = "image.built"
+	EventServiceDeployed = "service.deployed"
+	EventServiceEdited   = "service.edited"
+	EventServiceStopped  = "service.stopped"
+	EventServiceStarted  = "service.started"
+	EventServiceRemoved  = "service.removed"
+	// Solo lo envía el endpoint de prueba, no se puede filtrar
+	EventPing = "ping"
+)
+
+var lifecycleEvents = []string{
+	EventImageBuilt, EventServiceDeployed, EventServiceEdited,
+	EventServiceStopped, EventServiceStarted, EventServiceRemoved,
+}
+
+var errDeliveryNotFailed = errors.New("only failed deliveries can be replayed")
+
+// NewWebhookSubscription valida la petición; sin eventos la suscripción recibe todos
+func NewWebhookSubscription(userID string, req webhookSubscriptionRequest) (WebhookSubscription, error) {
+	sub := WebhookSubscription{
+		UserID:      userID,
+		URL:         req.URL,
+		Events:      req.Events,
+		Format:      req.Format,
+		Description: req.Description,
+		CreatedAt:   time.Now(),
+	}
+	if sub.Format == "" {
+		sub.Format = "json"
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	for _, e := range sub.Events {
+		if !slices.Contains(lifecycleEvents, e) {
+			return sub, fmt.Errorf("%w: unknown event %q", errInvalidWebhook, e)
+		}
+	}
+	if err := ValidateWebhookURL(sub.URL); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+func (s *store) CreateWebhookSubscription(sub WebhookSubscription) (*WebhookSubscription, error) {
+	s, end := s.trace("store.CreateWebhookSubscription")
+	defer end()
+	collection := s.database.Collection(subscriptionsCollection)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"userId": sub.UserID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count webhooks: %w", err)
+	}
+	if webhookMaxSubscriptions > 0 && count >= int64(webhookMaxSubscriptions) {
+		return nil, fmt.Errorf("%w: at most %d webhooks per user", errInvalidWebhook, webhookMaxSubscriptions)
+	}
+
+	sub.ID = primitive.NewObjectID()
+	sub.Secret = newWebhookSecret()
+	if _, err := collection.InsertOne(ctx, sub); err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %w", err)
+	}
+	return &sub, nil
+}
+
+func (s *store) GetWebhookSubscriptions(userID string) ([]WebhookSubscription, error) {
+	s, end := s.trace("store.GetWebhookSubscriptions")
+	defer end()
+	return s.findWebhookSubscriptions(bson.M{"userId": userID})
+}
+
+// GetWebhookSubscription devuelve nil si no existe o es de otro usuario
+func (s *store) GetWebhookSubscription(userID, id string) (*WebhookSubscription, error) {
+	s, end := s.trace("store.GetWebhookSubscription")
+	defer end()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+	subs, err := s.findWebhookSubscriptions(bson.M{"_id": oid, "userId": userID})
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return &subs[0], nil
+}
+
+func (s *store) findWebhookSubscriptions(filter bson.M) ([]WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cur, err := s.database.Collection(subscriptionsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhooks: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	subs := []WebhookSubscription{}
+	if err := cur.All(ctx, &subs); err != nil {
+		return nil, fmt.Errorf("failed to decode webhooks: %w", err)
+	}
+	return subs, nil
+}
+
+// DeleteWebhookSubscription devuelve false si no existe o es de otro usuario. Los
+// envíos ya encolados se siguen intentando.
+func (s *store) DeleteWebhookSubscription(userID, id string) (bool, error) {
+	s, end := s.trace("store.DeleteWebhookSubscription")
+	defer end()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.database.Collection(subscriptionsCollection).DeleteOne(ctx, bson.M{"_id": oid, "userId": userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return false, nil
+	}
+
+	if err := s.CancelDeliveries(userID, "webhook", id); err != nil {
+		webhookLog.ErrorContext(ctx, "error cancelando envíos pendientes", "sourceId", id, "error", err)
+	}
+	return true, nil
+}
+
+func (sub *WebhookSubscription) target() WebhookTarget {
+	return WebhookTarget{
+		UserID:   sub.UserID,
+		Source:   "webhook",
+		SourceID: sub.ID.Hex(),
+		URL:      sub.URL,
+		Format:   sub.Format,
+		Secret:   sub.Secret,
+	}
+}
+
+// PublishEvent encola el evento para cada suscripción del usuario que lo pida. Los
+// errores solo se registran: un webhook caído no debe romper la operación.
+func (s *store) PublishEvent(userID, event string, data ServiceEvent) {
+	s, end := s.trace("store.PublishEvent")
+	defer end()
+
+	subs, err := s.findWebhookSubscriptions(bson.M{
+		"userId": userID,
+		"$or":    bson.A{bson.M{"events": event}, bson.M{"events": bson.M{"$size": 0}}},
+	})
+	if err != nil {
+		webhookLog.ErrorContext(s.baseContext(), "error leyendo suscripciones", "error", err)
+		return
+	}
+
+	if data.At.IsZero() {
+		data.At = time.Now()
+	}
+	text := fmt.Sprintf(":package: *%s* en `%s`", event, data.ContainerName)
+	if data.Release > 0 {
+		text += fmt.Sprintf(" (v%d)", data.Release)
+	}
+	for _, sub := range subs {
+		if err := s.EnqueueWebhook(sub.target(), event, data, text); err != nil {
+			webhookLog.ErrorContext(s.baseContext(), "error encolando evento", "webhookId", sub.ID.Hex(), "event", event, "error", err)
+		}
+	}
+}
+
+// PingWebhook encola un evento de prueba para la suscripción
+func (s *store) PingWebhook(sub *WebhookSubscription) error {
+	s, end := s.trace("store.PingWebhook")
+	defer end()
+	data := map[string]any{"webhookId": sub.ID.Hex(), "events": sub.Events}
+	return s.EnqueueWebhook(sub.target(), EventPing, data, ":wave: ping de la plataforma")
+}
+
+// ReplayDelivery vuelve a poner en cola un envío fallido del usuario. Devuelve
+// false si no existe o es de otro usuario.
+func (s *store) ReplayDelivery(userID, id string) (bool, error) {
+	s, end := s.trace("store.ReplayDelivery")
+	defer end()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+	collection := s.database.Collection(deliveriesCollection)
+
+	var d WebhookDelivery
+	if err := collection.FindOne(ctx, bson.M{"_id": oid, "userId": userID}).Decode(&d); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to fetch webhook delivery: %w", err)
+	}
+	if d.Status != "failed" {
+		return true, errDeliveryNotFailed
+	}
+
+	// Se reenvía el mismo cuerpo, con el mismo ID, para que el receptor pueda deduplicar
+	update := bson.M{"$set": bson.M{
+		"status":        "pending",
+		"attempts":      0,
+		"nextAttemptAt": time.Now(),
+	}}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": oid, "status": "failed"}, update); err != nil {
+		return true, fmt.Errorf("failed to replay webhook delivery: %w", err)
+	}
+
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
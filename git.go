@@ -0,0 +1,220 @@
+package main
+
+//Builds a partir de un repositorio Git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	gitCloneTimeout = GetEnvDuration("GIT_CLONE_TIMEOUT", 2*time.Minute)
+	gitAllowLocal   = GetEnv("GIT_ALLOW_LOCAL", "false") == "true" // permite file:// y rutas locales
+	// Por defecto no se clona desde direcciones privadas, donde están MongoDB y Docker
+	gitAllowPrivate = GetEnv("GIT_ALLOW_PRIVATE", "false") == "true"
+)
+
+// checkGitURL acepta solo https://; file:// y rutas locales solo si GIT_ALLOW_LOCAL=true.
+// http://, git:// y ssh:// no se aceptan: no se puede controlar a qué dirección se
+// conectan ni a dónde redirigen.
+func checkGitURL(repo string) error {
+	if repo == "" || strings.HasPrefix(repo, "-") {
+		return fmt.Errorf("invalid repository URL")
+	}
+
+	if strings.HasPrefix(repo, "https://") {
+		u, err := neturl.Parse(repo)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("invalid repository URL")
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(repo, "file://") || filepath.IsAbs(repo) {
+		if !gitAllowLocal {
+			return fmt.Errorf("local repositories are not allowed")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported repository URL %q: only https:// is allowed", repo)
+}
+
+// gitResolveConfig resuelve el host del repositorio, rechaza las direcciones
+// privadas y fija la IP elegida con http.curloptResolve, para que git no vuelva a
+// resolver el nombre a otra dirección después del chequeo. También desactiva las
+// redirecciones.
+func gitResolveConfig(ctx context.Context, repo string) ([]string, error) {
+	if !strings.HasPrefix(repo, "https://") {
+		return nil, nil
+	}
+	u, err := neturl.Parse(repo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL")
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("could not resolve repository host %s", host)
+	}
+	if !gitAllowPrivate {
+		for _, a := range addrs {
+			if !isPublicIP(a.IP) {
+				return nil, fmt.Errorf("repository host %s resolves to a private address", host)
+			}
+		}
+	}
+
+	ip := addrs[0].IP.String()
+	if addrs[0].IP.To4() == nil {
+		ip = "[" + ip + "]"
+	}
+	return []string{
+		"--config", "http.curloptResolve=" + host + ":" + port + ":" + ip,
+		"--config", "http.followRedirects=false",
+	}, nil
+}
+
+// cloneGitRepo clona repo en dir y deja el árbol en ref (rama, tag o commit).
+// Devuelve el SHA del commit resuelto.
+func (s *store) cloneGitRepo(repo, ref, dir string) (string, error) {
+	s, end := s.trace("store.cloneGitRepo")
+	defer end()
+
+	if err := checkGitURL(repo); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref %q", ref)
+	}
+
+	ctx, cancel := context.WithTimeout(s.baseContext(), gitCloneTimeout)
+	defer cancel()
+
+	pinned, err := gitResolveConfig(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{"clone", "--quiet", "--no-checkout"}, pinned...)
+	if _, err := runGit(ctx, "", append(args, "--", repo, dir)...); err != nil {
+		return "", err
+	}
+
+	candidates := []string{"HEAD"}
+	if ref != "" {
+		candidates = []string{"origin/" + ref, ref}
+	}
+
+	var sha string
+	for _, c := range candidates {
+		out, err := runGit(ctx, dir, "rev-parse", "--verify", "--quiet", c+"^{commit}")
+		if err == nil {
+			sha = strings.TrimSpace(out)
+			break
+		}
+	}
+	if sha == "" {
+		return "", fmt.Errorf("ref %q not found in repository", ref)
+	}
+
+	if _, err := runGit(ctx, dir, "checkout", "--quiet", "--detach", sha); err != nil {
+		return "", err
+	}
+
+	return sha, nil
+}
+
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	args = append([]string{"-c", "protocol.ext.allow=never"}, args...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("git %s timed out after %s", args[2], gitCloneTimeout)
+		}
+		return "", fmt.Errorf("git %s failed: %v: %s", args[2], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// resolveSubdir devuelve la ruta de subdir dentro de root, sin permitir salir de root
+// con ".." ni con enlaces simbólicos.
+func resolveSubdir(root, subdir string) (string, error) {
+	dir := filepath.Join(root, filepath.Clean("/"+subdir))
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("subdirectory %q not found", subdir)
+	}
+
+	rel, err := filepath.Rel(realRoot, realDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("subdirectory %q escapes the repository", subdir)
+	}
+
+	info, err := os.Stat(realDir)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("subdirectory %q is not a directory", subdir)
+	}
+
+	return realDir, nil
+}
+
+// prepareGitContext completa el contexto con los archivos base si el repositorio
+// no trae su propio Dockerfile.
+func prepareGitContext(contextDir string) error {
+	// Los archivos se revisan sin seguir enlaces: un Dockerfile o server.py que sea
+	// un enlace haría escribir o leer fuera del contexto
+	dockerfile := filepath.Join(contextDir, "Dockerfile")
+	if _, err := os.Lstat(dockerfile); err == nil {
+		return checkContextFile(dockerfile)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := checkContextFile(filepath.Join(contextDir, "app.py")); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: el repositorio debe tener un Dockerfile o un app.py", errInvalidBuildInput)
+		}
+		return err
+	}
+
+	// El server.py del repositorio se reemplaza por el de la plataforma; Remove borra
+	// el enlace, no su destino
+	serverPy := filepath.Join(contextDir, "server.py")
+	if err := os.Remove(serverPy); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("no se pudo reemplazar server.py: %v", err)
+	}
+	if err := copyFile("./files/server.py", serverPy); err != nil {
+		return fmt.Errorf("no se pudo copiar server.py: %v", err)
+	}
+	if err := copyFile("./files/Dockerfile", dockerfile); err != nil {
+		return fmt.Errorf("no se pudo usar Dockerfile por defecto: %v", err)
+	}
+
+	return nil
+}
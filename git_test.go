@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitTest corre git en dir con un autor fijo y falla el test si git falla
+func gitTest(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// bareTestRepo crea un repositorio bare local con main (v1 y v2), el tag v1 y la
+// rama feature, y devuelve su ruta y el SHA de cada versión
+func bareTestRepo(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git no está instalado")
+	}
+
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	gitTest(t, "", "init", "--quiet", "--bare", "-b", "main", bare)
+
+	work := t.TempDir()
+	gitTest(t, work, "init", "--quiet", "-b", "main")
+	shas := map[string]string{}
+	commit := func(version string) {
+		writeTestFile(t, filepath.Join(work, "app.py"), version)
+		gitTest(t, work, "add", "app.py")
+		gitTest(t, work, "commit", "--quiet", "-m", version)
+		shas[version] = gitTest(t, work, "rev-parse", "HEAD")
+	}
+
+	commit("v1")
+	gitTest(t, work, "tag", "v1")
+	commit("v2")
+	gitTest(t, work, "checkout", "--quiet", "-b", "feature", "v1")
+	commit("feature")
+	gitTest(t, work, "push", "--quiet", bare, "main", "feature", "v1")
+
+	return bare, shas
+}
+
+func TestCloneGitRepo(t *testing.T) {
+	saved := gitAllowLocal
+	defer func() { gitAllowLocal = saved }()
+	gitAllowLocal = true
+
+	bare, shas := bareTestRepo(t)
+
+	tests := []struct {
+		name    string
+		repo    string
+		ref     string
+		want    string // versión esperada en app.py; vacío si debe fallar
+		wantErr string
+	}{
+		{name: "default branch", repo: "file://" + bare, want: "v2"},
+		{name: "absolute path", repo: bare, want: "v2"},
+		{name: "branch", repo: "file://" + bare, ref: "feature", want: "feature"},
+		{name: "tag", repo: "file://" + bare, ref: "v1", want: "v1"},
+		{name: "commit", repo: "file://" + bare, ref: shas["v1"], want: "v1"},
+		{name: "short commit", repo: "file://" + bare, ref: shas["feature"][:10], want: "feature"},
+		{name: "missing ref", repo: "file://" + bare, ref: "nope", wantErr: "not found"},
+		{name: "option as ref", repo: "file://" + bare, ref: "--upload-pack=touch", wantErr: "invalid ref"},
+		{name: "missing repository", repo: "file://" + bare + "-missing", wantErr: "git clone failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "repo")
+			sha, err := (&store{}).cloneGitRepo(tt.repo, tt.ref, dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("cloneGitRepo() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sha != shas[tt.want] {
+				t.Errorf("cloneGitRepo() sha = %s, want %s", sha, shas[tt.want])
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "app.py"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("app.py = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloneGitRepoRejectsLocalByDefault(t *testing.T) {
+	saved := gitAllowLocal
+	defer func() { gitAllowLocal = saved }()
+	gitAllowLocal = false
+
+	bare, _ := bareTestRepo(t)
+	for _, repo := range []string{"file://" + bare, bare} {
+		dir := filepath.Join(t.TempDir(), "repo")
+		if _, err := (&store{}).cloneGitRepo(repo, "", dir); err == nil {
+			t.Errorf("cloneGitRepo(%q) should be rejected without GIT_ALLOW_LOCAL", repo)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("cloneGitRepo(%q) created %s", repo, dir)
+		}
+	}
+}
+
+func TestCheckGitURL(t *testing.T) {
+	saved := gitAllowLocal
+	defer func() { gitAllowLocal = saved }()
+	gitAllowLocal = false
+
+	tests := []struct {
+		repo  string
+		valid bool
+	}{
+		{"https://github.com/user/repo.git", true},
+		{"https://gitlab.example.com:8443/group/repo", true},
+		{"http://github.com/user/repo.git", false},
+		{"git://github.com/user/repo.git", false},
+		{"ssh://git@github.com/user/repo.git", false},
+		{"git@github.com:user/repo.git", false},
+		{"ext::sh -c touch% /tmp/pwned", false},
+		{"--upload-pack=touch /tmp/pwned", false},
+		{"https://", false},
+		{"file:///srv/repo.git", false},
+		{"/srv/repo.git", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			if err := checkGitURL(tt.repo); (err == nil) != tt.valid {
+				t.Fatalf("checkGitURL(%q) = %v, valid = %v", tt.repo, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestPrepareGitContextSymlinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir, victim string)
+	}{
+		{
+			name: "dangling Dockerfile symlink",
+			setup: func(t *testing.T, dir, victim string) {
+				writeTestFile(t, filepath.Join(dir, "app.py"), "x")
+				if err := os.Symlink(victim, filepath.Join(dir, "Dockerfile")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "server.py symlink",
+			setup: func(t *testing.T, dir, victim string) {
+				writeTestFile(t, filepath.Join(dir, "app.py"), "x")
+				if err := os.Symlink(victim, filepath.Join(dir, "server.py")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "app.py symlink",
+			setup: func(t *testing.T, dir, victim string) {
+				if err := os.Symlink(victim, filepath.Join(dir, "app.py")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			victim := filepath.Join(t.TempDir(), "victim")
+			tt.setup(t, dir, victim)
+
+			// prepareGitContext copia desde ./files, relativo al directorio del repo
+			if err := os.Chdir(wd); err != nil {
+				t.Fatal(err)
+			}
+			prepareGitContext(dir)
+
+			if _, err := os.Stat(victim); !os.IsNotExist(err) {
+				t.Fatalf("a file was written through the symlink: %v", err)
+			}
+		})
+	}
+}
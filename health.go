@@ -0,0 +1,311 @@
+package main
+
+//Sondas de disponibilidad (readiness) y monitoreo continuo de salud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const servicePort = "8000"
+
+var (
+	healthCheckInterval = GetEnvDuration("HEALTH_CHECK_INTERVAL", 30*time.Second)
+	// Por defecto se espera a que el puerto acepte conexiones; "running" solo mira
+	// el estado del contenedor y da por listo un servicio que todavía arranca
+	healthDefaultProbe = GetEnv("HEALTH_DEFAULT_PROBE", "tcp")
+	healthProbeImage   = GetEnv("HEALTH_PROBE_IMAGE", "busybox:latest")
+	// Durante el arranque los fallos no cuentan para Retries
+	healthStartPeriod = GetEnvInt("HEALTH_START_PERIOD_SECONDS", 30)
+)
+
+// withDefaults completa los campos vacíos; el tipo por defecto es HEALTH_DEFAULT_PROBE
+func (p *HealthProbe) withDefaults() HealthProbe {
+	var probe HealthProbe
+	if p != nil {
+		probe = *p
+	}
+	if probe.Type == "" {
+		probe.Type = healthDefaultProbe
+	}
+	if probe.Type == "http" && probe.Path == "" {
+		probe.Path = "/"
+	}
+	if probe.TimeoutSeconds <= 0 {
+		probe.TimeoutSeconds = 2
+	}
+	if probe.IntervalSeconds <= 0 {
+		probe.IntervalSeconds = 1
+	}
+	if probe.Retries <= 0 {
+		probe.Retries = 3
+	}
+	if probe.StartPeriodSeconds <= 0 {
+		probe.StartPeriodSeconds = healthStartPeriod
+	}
+	return probe
+}
+
+func (p *HealthProbe) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Type {
+	case "", "tcp", "docker", "running":
+	case "http":
+		if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("probe path must start with /")
+		}
+	default:
+		return fmt.Errorf("unknown probe type %q", p.Type)
+	}
+	if p.TimeoutSeconds < 0 || p.IntervalSeconds < 0 || p.Retries < 0 || p.StartPeriodSeconds < 0 {
+		return fmt.Errorf("probe timeout, interval, retries and start period cannot be negative")
+	}
+	if p.TimeoutSeconds > 60 || p.IntervalSeconds > 300 || p.Retries > 100 || p.StartPeriodSeconds > 600 {
+		return fmt.Errorf("probe timeout, interval, retries or start period out of range")
+	}
+	return nil
+}
+
+// WaitReady sondea el contenedor hasta que responde, falla p.Retries veces
+// seguidas una vez pasado p.StartPeriodSeconds o se supera deployTimeout. Falla
+// antes si el contenedor termina.
+func (s *store) WaitReady(containerID string, probe *HealthProbe) (ProbeResult, error) {
+	s, end := s.trace("store.WaitReady")
+	defer end()
+	p := probe.withDefaults()
+	ctx, cancel := context.WithTimeout(s.baseContext(), deployTimeout)
+	defer cancel()
+
+	started := time.Now()
+	startPeriodEnds := started.Add(time.Duration(p.StartPeriodSeconds) * time.Second)
+	result := ProbeResult{Type: p.Type}
+	failures := 0
+
+wait:
+	for {
+		result.Attempts++
+		info, err := s.client.ContainerInspect(ctx, containerID)
+		if err != nil {
+			result.Message = err.Error()
+			break
+		}
+		if info.State.Status == "exited" || info.State.Status == "dead" {
+			result.Message = fmt.Sprintf("container exited with code %d", info.State.ExitCode)
+			break
+		}
+
+		err = s.probeOnce(ctx, info, p)
+		if err == nil {
+			result.Healthy = true
+			result.Message = ""
+			break
+		}
+		result.Message = err.Error()
+
+		// Mientras el servicio arranca (o Docker no termina el start period del
+		// HEALTHCHECK) los fallos no cuentan
+		starting := time.Now().Before(startPeriodEnds) ||
+			(p.Type == "docker" && info.State.Health != nil && info.State.Health.Status == container.Starting)
+		if !starting {
+			failures++
+		}
+		if failures >= p.Retries {
+			result.Message = fmt.Sprintf("failed %d times in a row: %s", failures, result.Message)
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Message = fmt.Sprintf("not ready after %s: %s", deployTimeout, result.Message)
+			break wait
+		case <-time.After(time.Duration(p.IntervalSeconds) * time.Second):
+		}
+	}
+
+	result.DurationMs = time.Since(started).Milliseconds()
+	result.CheckedAt = time.Now()
+	if !result.Healthy {
+		return result, fmt.Errorf("readiness probe failed: %s", result.Message)
+	}
+	return result, nil
+}
+
+// probeOnce ejecuta una sola vez la sonda contra el contenedor ya inspeccionado
+func (s *store) probeOnce(ctx context.Context, info container.InspectResponse, p HealthProbe) error {
+	timeout := time.Duration(p.TimeoutSeconds) * time.Second
+
+	if p.Type == "docker" {
+		if info.State.Health == nil {
+			return fmt.Errorf("image has no HEALTHCHECK")
+		}
+		if info.State.Health.Status != container.Healthy {
+			return fmt.Errorf("docker health status is %s", info.State.Health.Status)
+		}
+		return nil
+	}
+
+	if !info.State.Running || info.State.Restarting {
+		return fmt.Errorf("container is %s", info.State.Status)
+	}
+	if p.Type == "running" {
+		return nil
+	}
+
+	networkName, ip := containerAddress(info)
+	if ip == "" {
+		return fmt.Errorf("container has no IP address")
+	}
+	target := net.JoinHostPort(ip, servicePort)
+
+	// La API no está en la red del usuario; las sondas tcp y http corren en un
+	// contenedor efímero conectado a esa red
+	seconds := strconv.Itoa(p.TimeoutSeconds)
+	cmd := []string{"nc", "-z", "-w", seconds, ip, servicePort}
+	if p.Type == "http" {
+		// traceparent va como --header para que el servicio continúe la traza
+		cmd = append([]string{"wget", "-q", "-O", "/dev/null", "-T", seconds}, traceHeaderArgs(ctx)...)
+		cmd = append(cmd, "http://"+target+p.Path)
+	}
+	return s.runProbeSidecar(ctx, networkName, cmd, timeout)
+}
+
+// runProbeSidecar ejecuta cmd en healthProbeImage dentro de la red del servicio;
+// la sonda falla si el comando termina con un código distinto de 0
+func (s *store) runProbeSidecar(ctx context.Context, networkName string, cmd []string, timeout time.Duration) error {
+	if err := s.ensureImage(healthProbeImage); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+10*time.Second)
+	defer cancel()
+
+	resp, err := s.client.ContainerCreate(ctx, &container.Config{
+		Image:  healthProbeImage,
+		Cmd:    cmd,
+		User:   "65534",
+		Labels: map[string]string{"plataforma.probe": "true"},
+	}, &container.HostConfig{
+		NetworkMode:    container.NetworkMode(networkName),
+		CapDrop:        []string{"ALL"},
+		ReadonlyRootfs: true,
+		SecurityOpt:    []string{"no-new-privileges:true"},
+		Resources:      container.Resources{Memory: 16 * 1024 * 1024, NanoCPUs: 100_000_000},
+	}, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create probe container: %w", err)
+	}
+	defer s.discardContainer(resp.ID)
+
+	if err := s.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start probe container: %w", err)
+	}
+
+	statusCh, errCh := s.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("probe container failed: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("%s %s failed with code %d", cmd[0], cmd[len(cmd)-1], status.StatusCode)
+		}
+	}
+	return nil
+}
+
+// containerAddress devuelve la primera red del contenedor que tiene IP
+func containerAddress(info container.InspectResponse) (string, string) {
+	if info.NetworkSettings == nil {
+		return "", ""
+	}
+	for name, network := range info.NetworkSettings.Networks {
+		if network != nil && network.IPAddress != "" {
+			return name, network.IPAddress
+		}
+	}
+	return "", ""
+}
+
+// CheckHealth sondea una vez el contenedor y guarda el resultado en su registro
+func (s *store) CheckHealth(rec ContainerRecord) error {
+	s, end := s.trace("store.CheckHealth")
+	defer end()
+	p := rec.Probe.withDefaults()
+	ctx, cancel := context.WithTimeout(s.baseContext(), time.Duration(p.TimeoutSeconds+5)*time.Second)
+	defer cancel()
+
+	health := HealthStatus{Status: "healthy", CheckedAt: time.Now()}
+	if rec.Health != nil {
+		health.ConsecutiveFailures = rec.Health.ConsecutiveFailures
+	}
+
+	info, err := s.client.ContainerInspect(ctx, rec.DockerName)
+	if err == nil {
+		err = s.probeOnce(ctx, info, p)
+	}
+
+	if err != nil {
+		// Se mantiene el estado anterior hasta acumular p.Retries fallos seguidos
+		health.ConsecutiveFailures++
+		health.Message = err.Error()
+		health.Status = "unknown"
+		if rec.Health != nil {
+			health.Status = rec.Health.Status
+		}
+		if health.ConsecutiveFailures >= p.Retries {
+			health.Status = "unhealthy"
+		}
+	} else {
+		health.ConsecutiveFailures = 0
+	}
+
+	// Solo se avisa al pasar a unhealthy, no en cada sonda fallida
+	if health.Status == "unhealthy" && (rec.Health == nil || rec.Health.Status != "unhealthy") {
+		s.FireAlerts(rec.UserID, rec.ContainerName, "unhealthy", 0, health.Message,
+			map[string]any{"consecutiveFailures": health.ConsecutiveFailures})
+	}
+
+	return s.UpdateContainerHealth(rec.UserID, rec.ContainerName, health)
+}
+
+// checkAllHealth prueba una vez todos los servicios que deberían estar corriendo
+func checkAllHealth(s *store) {
+	s, span := startSpan(s, "health.check")
+	defer span.End()
+
+	records, err := s.GetAllContainers()
+	if err != nil {
+		healthLog.ErrorContext(s.baseContext(), "error leyendo containers", "error", err)
+		return
+	}
+	for _, rec := range records {
+		if !rec.Status {
+			continue
+		}
+		if err := s.CheckHealth(rec); err != nil {
+			healthLog.ErrorContext(s.baseContext(), "error guardando salud", "containerName", rec.ContainerName, "error", err)
+		}
+	}
+}
+
+func StartHealthMonitor(ctx context.Context, s *store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			checkAllHealth(s)
+		case <-ctx.Done():
+			healthLog.Info("bucle detenido")
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestHealthProbeValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe *HealthProbe
+		valid bool
+	}{
+		{"nil", nil, true},
+		{"empty uses default", &HealthProbe{}, true},
+		{"tcp", &HealthProbe{Type: "tcp"}, true},
+		{"docker", &HealthProbe{Type: "docker"}, true},
+		{"running", &HealthProbe{Type: "running"}, true},
+		{"http with path", &HealthProbe{Type: "http", Path: "/health"}, true},
+		{"http without path", &HealthProbe{Type: "http"}, true},
+		{"http relative path", &HealthProbe{Type: "http", Path: "health"}, false},
+		{"unknown type", &HealthProbe{Type: "grpc"}, false},
+		{"limits", &HealthProbe{Type: "tcp", TimeoutSeconds: 60, IntervalSeconds: 300, Retries: 100, StartPeriodSeconds: 600}, true},
+		{"negative timeout", &HealthProbe{TimeoutSeconds: -1}, false},
+		{"negative interval", &HealthProbe{IntervalSeconds: -1}, false},
+		{"negative retries", &HealthProbe{Retries: -1}, false},
+		{"negative start period", &HealthProbe{StartPeriodSeconds: -1}, false},
+		{"timeout too long", &HealthProbe{TimeoutSeconds: 61}, false},
+		{"interval too long", &HealthProbe{IntervalSeconds: 301}, false},
+		{"too many retries", &HealthProbe{Retries: 101}, false},
+		{"start period too long", &HealthProbe{StartPeriodSeconds: 601}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.probe.Validate(); (err == nil) != tt.valid {
+				t.Fatalf("Validate() = %v, valid = %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestHealthProbeWithDefaults(t *testing.T) {
+	defaults := HealthProbe{Type: healthDefaultProbe, TimeoutSeconds: 2, IntervalSeconds: 1, Retries: 3, StartPeriodSeconds: healthStartPeriod}
+
+	tests := []struct {
+		name  string
+		probe *HealthProbe
+		want  HealthProbe
+	}{
+		{"nil", nil, defaults},
+		{"empty", &HealthProbe{}, defaults},
+		{
+			name:  "http gets a path",
+			probe: &HealthProbe{Type: "http"},
+			want:  HealthProbe{Type: "http", Path: "/", TimeoutSeconds: 2, IntervalSeconds: 1, Retries: 3, StartPeriodSeconds: healthStartPeriod},
+		},
+		{
+			name:  "tcp does not get a path",
+			probe: &HealthProbe{Type: "tcp"},
+			want:  HealthProbe{Type: "tcp", TimeoutSeconds: 2, IntervalSeconds: 1, Retries: 3, StartPeriodSeconds: healthStartPeriod},
+		},
+		{
+			name:  "values are kept",
+			probe: &HealthProbe{Type: "http", Path: "/ready", TimeoutSeconds: 5, IntervalSeconds: 10, Retries: 1, StartPeriodSeconds: 90},
+			want:  HealthProbe{Type: "http", Path: "/ready", TimeoutSeconds: 5, IntervalSeconds: 10, Retries: 1, StartPeriodSeconds: 90},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.probe.withDefaults(); got != tt.want {
+				t.Fatalf("withDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// withDefaults no modifica la sonda guardada
+	probe := &HealthProbe{Type: "http"}
+	probe.withDefaults()
+	if *probe != (HealthProbe{Type: "http"}) {
+		t.Errorf("withDefaults() modified the probe: %+v", *probe)
+	}
+}
+
+func TestContainerAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		networks    map[string]*network.EndpointSettings
+		wantNetwork string
+		wantIP      string
+	}{
+		{"no networks", nil, "", ""},
+		{"network without ip", map[string]*network.EndpointSettings{"tenant-a": {}}, "", ""},
+		{"nil endpoint", map[string]*network.EndpointSettings{"tenant-a": nil}, "", ""},
+		{"tenant network", map[string]*network.EndpointSettings{"tenant-a": {IPAddress: "172.20.0.5"}}, "tenant-a", "172.20.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := container.InspectResponse{NetworkSettings: &container.NetworkSettings{Networks: tt.networks}}
+			name, ip := containerAddress(info)
+			if name != tt.wantNetwork || ip != tt.wantIP {
+				t.Fatalf("containerAddress() = %q, %q, want %q, %q", name, ip, tt.wantNetwork, tt.wantIP)
+			}
+		})
+	}
+
+	if name, ip := containerAddress(container.InspectResponse{}); name != "" || ip != "" {
+		t.Errorf("containerAddress() without network settings = %q, %q", name, ip)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+//Historial de los servicios. Cada entrada tiene un tipo de evento, quién lo
+//causó y, según el caso, el motivo, el código de salida y la release.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	HistoryCreated      = "created"
+	HistoryBuilt        = "built"
+	HistoryBuildFailed  = "build-failed"
+	HistoryEdited       = "edited"
+	HistoryEditFailed   = "edit-failed"
+	HistoryDeployed     = "deployed" // rollback a una release
+	HistoryDeployFailed = "deploy-failed"
+	HistoryStarted      = "started"
+	HistoryStopped      = "stopped"
+	HistoryRestarted    = "restarted" // por un cambio de configuración
+	HistoryRemoved      = "removed"
+	HistoryCrashed      = "crashed"
+	HistoryReconciled   = "restarted-by-reconciler"
+)
+
+var historyEvents = []string{
+	HistoryCreated, HistoryBuilt, HistoryBuildFailed, HistoryEdited, HistoryEditFailed,
+	HistoryDeployed, HistoryDeployFailed, HistoryStarted, HistoryStopped, HistoryRestarted,
+	HistoryRemoved, HistoryCrashed, HistoryReconciled,
+}
+
+// Actores que no son un usuario
+const (
+	actorReconciler = "reconciler"
+	actorDocker     = "docker"
+	actorMigration  = "migration"
+)
+
+// Eventos que cuentan como error en el gráfico; detener o eliminar un servicio no lo es
+var historyFailures = map[string]bool{
+	HistoryBuildFailed:  true,
+	HistoryEditFailed:   true,
+	HistoryDeployFailed: true,
+	HistoryCrashed:      true,
+}
+
+// Eventos que cuentan como despliegue en el gráfico
+var historyDeployments = map[string]bool{
+	HistoryCreated:  true,
+	HistoryEdited:   true,
+	HistoryDeployed: true,
+	HistoryStarted:  true,
+}
+
+func (u ContainerUpdate) Failed() bool {
+	return historyFailures[u.Event]
+}
+
+// recordHistory guarda la entrada y solo registra el error; para los caminos en
+// los que el historial no debe cortar la operación
+func (s *store) recordHistory(update ContainerUpdate) {
+	if update.CreatedAt.IsZero() {
+		update.CreatedAt = time.Now()
+	}
+	if _, err := s.SaveUpdate(update); err != nil {
+		historyLog.ErrorContext(s.baseContext(), "error guardando historial", "containerName", update.ContainerName, "event", update.Event, "error", err)
+	}
+}
+
+// MigrateHistoryEvents convierte las entradas viejas con "status" en eventos. No se
+// puede saber qué pasó realmente, así que true pasa a started y false a stopped:
+// las entradas viejas no cuentan como errores.
+func MigrateHistoryEvents(s *store) {
+	s, span := startSpan(s, "migrate.historyEvents")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(s.baseContext(), time.Minute)
+	defer cancel()
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"event":  bson.M{"$cond": bson.A{"$status", HistoryStarted, HistoryStopped}},
+			"actor":  actorMigration,
+			"reason": "migrated from status",
+		}}},
+		{{Key: "$unset", Value: "status"}},
+	}
+	res, err := s.database.Collection("history").UpdateMany(ctx, bson.M{"event": bson.M{"$exists": false}}, update)
+	if err != nil {
+		migrateLog.ErrorContext(ctx, "error migrando historial", "error", err)
+		return
+	}
+	if res.ModifiedCount > 0 {
+		migrateLog.InfoContext(ctx, "historial migrado", "entries", res.ModifiedCount)
+	}
+}
+
+// dailyHistory es lo que muestra el gráfico para un día
+type dailyHistory struct {
+	Deployments int
+	Errors      int
+}
+
+// CountHistoryByDay cuenta en MongoDB los despliegues y fallos del usuario desde
+// since, agrupados por día (2006-01-02) en la zona loc
+func (s *store) CountHistoryByDay(userID string, since time.Time, loc *time.Location) (map[string]dailyHistory, error) {
+	s, end := s.trace("store.CountHistoryByDay")
+	defer end()
+	collection := s.database.Collection("history")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+
+	events := bson.A{}
+	for _, event := range historyEvents {
+		if historyDeployments[event] || historyFailures[event] {
+			events = append(events, event)
+		}
+	}
+
+	// MongoDB no conoce "Local"; en ese caso se usa el desfase actual
+	timezone := loc.String()
+	if loc == time.Local {
+		timezone = time.Now().In(loc).Format("-07:00")
+	}
+
+	cur, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{
+			"userId":    userID,
+			"createdAt": bson.M{"$gte": since},
+			"event":     bson.M{"$in": events},
+		}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{
+				"day":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createdAt", "timezone": timezone}},
+				"event": "$event",
+			},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate history: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	byDay := map[string]dailyHistory{}
+	for cur.Next(ctx) {
+		var row struct {
+			ID struct {
+				Day   string `bson:"day"`
+				Event string `bson:"event"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		if err := cur.Decode(&row); err != nil {
+			return nil, fmt.Errorf("failed to decode history counts: %w", err)
+		}
+		d := byDay[row.ID.Day]
+		if historyDeployments[row.ID.Event] {
+			d.Deployments += row.Count
+		} else {
+			d.Errors += row.Count
+		}
+		byDay[row.ID.Day] = d
+	}
+	if err := cur.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return byDay, nil
+}
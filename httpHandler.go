@@ -5,14 +5,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/go-playground/validator"
 )
 
@@ -33,11 +40,45 @@ func (h *handler) registerRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /start/container", WithJWTAuth(h.HandleStartContainer))
 	mux.HandleFunc("POST /edit/container", WithJWTAuth(h.HandleEditContainer))
 	mux.HandleFunc("POST /new/image", WithJWTAuth(h.HandleImageCreation))
+	mux.HandleFunc("POST /builds", WithJWTAuth(h.HandleGitBuild))
+	mux.HandleFunc("GET /containers/{name}", WithJWTAuth(h.HandleGetContainer))
+	mux.HandleFunc("GET /containers/{name}/logs", WithJWTAuth(h.HandleContainerLogs))
+	mux.HandleFunc("GET /containers/{name}/stats", WithJWTAuth(h.HandleContainerStats))
+	mux.HandleFunc("GET /containers/{name}/metrics", WithJWTAuth(h.HandleContainerMetrics))
+	mux.HandleFunc("GET /containers/{name}/releases", WithJWTAuth(h.HandleListReleases))
+	mux.HandleFunc("POST /containers/{name}/rollback", WithJWTAuth(h.HandleRollback))
+	mux.HandleFunc("GET /containers/{name}/env", WithJWTAuth(h.HandleGetEnv))
+	mux.HandleFunc("PUT /containers/{name}/env/{key}", WithJWTAuth(h.HandleSetEnv))
+	mux.HandleFunc("DELETE /containers/{name}/env/{key}", WithJWTAuth(h.HandleDeleteEnv))
+	mux.HandleFunc("PUT /containers/{name}/secrets/{key}", WithJWTAuth(h.HandleSetSecret))
+	mux.HandleFunc("DELETE /containers/{name}/secrets/{key}", WithJWTAuth(h.HandleDeleteSecret))
+	mux.HandleFunc("POST /containers/{name}/volumes", WithJWTAuth(h.HandleAttachVolume))
+	mux.HandleFunc("DELETE /containers/{name}/volumes/{volume}", WithJWTAuth(h.HandleDetachVolume))
+	mux.HandleFunc("POST /volumes", WithJWTAuth(h.HandleCreateVolume))
+	mux.HandleFunc("GET /volumes", WithJWTAuth(h.HandleListVolumes))
+	mux.HandleFunc("DELETE /volumes/{volume}", WithJWTAuth(h.HandleDeleteVolume))
 
 	mux.HandleFunc("GET /containers/list", WithJWTAuth(h.HandleListUserContainers))
 	mux.HandleFunc("GET /containers/graphic", WithJWTAuth(h.HandleListUserHistoryGraphic))
 	mux.HandleFunc("GET /containers/history", WithJWTAuth(h.HandleListUserHistory))
 	mux.HandleFunc("GET /containers/last", WithJWTAuth(h.HandleGetLastHistory))
+	mux.HandleFunc("GET /me/usage", WithJWTAuth(h.HandleGetUsage))
+	mux.HandleFunc("GET /me/network", WithJWTAuth(h.HandleGetNetworkPolicy))
+	mux.HandleFunc("PUT /me/network", WithJWTAuth(h.HandleUpdateNetworkPolicy))
+	mux.HandleFunc("POST /alerts", WithJWTAuth(h.HandleCreateAlertRule))
+	mux.HandleFunc("GET /alerts", WithJWTAuth(h.HandleListAlertRules))
+	mux.HandleFunc("DELETE /alerts/{id}", WithJWTAuth(h.HandleDeleteAlertRule))
+	mux.HandleFunc("GET /alerts/deliveries", WithJWTAuth(h.HandleListAlertDeliveries))
+	mux.HandleFunc("POST /webhooks", WithJWTAuth(h.HandleCreateWebhook))
+	mux.HandleFunc("GET /webhooks", WithJWTAuth(h.HandleListWebhooks))
+	mux.HandleFunc("DELETE /webhooks/{id}", WithJWTAuth(h.HandleDeleteWebhook))
+	mux.HandleFunc("POST /webhooks/{id}/ping", WithJWTAuth(h.HandlePingWebhook))
+	mux.HandleFunc("GET /webhooks/deliveries", WithJWTAuth(h.HandleListWebhookDeliveries))
+	mux.HandleFunc("POST /webhooks/deliveries/{id}/replay", WithJWTAuth(h.HandleReplayDelivery))
+	mux.HandleFunc("PUT /admin/containers/{userId}/{name}/security", WithAdminAuth(h.HandleSetSecurityExceptions))
+	mux.HandleFunc("GET /admin/images/gc", WithAdminAuth(h.HandleImageGCReport))
+
+	mux.Handle("GET /metrics", metricsHandler())
 
 }
 
@@ -45,7 +86,7 @@ func (h *handler) HandleUserRegister(w http.ResponseWriter, r *http.Request) {
 	var payload registerUser
 
 	if err := ParseJSON(r, &payload); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -59,23 +100,21 @@ func (h *handler) HandleUserRegister(w http.ResponseWriter, r *http.Request) {
 	// Marshal a JSON
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Println("Error marshalling payload:", err)
+		httpLog.ErrorContext(r.Context(), "error marshalling payload", "error", err)
 		return
 	}
 
 	// Send POST request
 	resp, err := http.Post(url+"/auth/"+proyectID+"/signup-direct", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Println("Error:", err)
+		authLog.ErrorContext(r.Context(), "error sending request", "error", err)
 		return
 	}
 	defer resp.Body.Close()
-	fmt.Println("Body:", payload)
 
-	// Read response
+	// Read response; el cuerpo trae los tokens, así que solo se registra el código
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Println("Status:", resp.Status)
-	fmt.Println("Response:", string(body))
+	authLog.InfoContext(r.Context(), "signup", "email", payload.Email, "status", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		WriteError(w, resp.StatusCode, string(body))
@@ -90,7 +129,7 @@ func (h *handler) HandleUserLogin(w http.ResponseWriter, r *http.Request) {
 	var payload loginUser
 
 	if err := ParseJSON(r, &payload); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -104,23 +143,21 @@ func (h *handler) HandleUserLogin(w http.ResponseWriter, r *http.Request) {
 	// Marshal a JSON
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Println("Error marshalling payload:", err)
+		httpLog.ErrorContext(r.Context(), "error marshalling payload", "error", err)
 		return
 	}
 
 	// Send POST request
 	resp, err := http.Post(url+"/auth/"+proyectID+"/login", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Println("Error:", err)
+		authLog.ErrorContext(r.Context(), "error sending request", "error", err)
 		return
 	}
 	defer resp.Body.Close()
-	fmt.Println("Body:", payload)
 
-	// Read response
+	// Read response; el cuerpo trae los tokens, así que solo se registra el código
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Println("Status:", resp.Status)
-	fmt.Println("Response:", string(body))
+	authLog.InfoContext(r.Context(), "login", "email", payload.Email, "status", resp.StatusCode)
 
 	// Extract only the tokens
 	var response loginResponse
@@ -144,22 +181,23 @@ func (h *handler) HandleUserLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) HandleNewContainer(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	//Same
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
 
-	println("User ID from context:", userID)
 	//
 
 	var payload contenedor //Tipo del JSON que se recibe en el ENDPOINT
 
 	//Same
 	if err := ParseJSON(r, &payload); err != nil { //Parsea el JSON al struct
-		log.Printf("Error parsing JSON: %v", err)
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -169,79 +207,227 @@ func (h *handler) HandleNewContainer(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
 		return
 	}
+	if err := payload.Probe.Validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid probe: "+err.Error())
+		return
+	}
+
+	// El nombre del servicio es "name" o, si no llega, la imagen
+	rawName := payload.Name
+	if rawName == "" {
+		rawName = payload.Image
+	}
+	name, err := ParseServiceName(rawName)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	//
 
-	//-Logica del ENDPOINT------->
-	err = h.store.NewContainer(payload.Image, payload.Image)
-	o := payload
+	// El nombre solo tiene que ser único entre los servicios del usuario
+	existing, err := store.GetContainer(userID, name)
 	if err != nil {
-		WriteError(w, http.StatusConflict, err.Error())
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing != nil {
+		WriteError(w, http.StatusConflict, "container with this name already exists")
 		return
 	}
 
-	// Guardar el contenedor en MongoDB
+	// Si la imagen fue construida aquí, el contenedor arranca en su última release;
+	// si no, payload.Image es una imagen pública
+	var release *Release
+	imageName, imageErr := ParseServiceName(payload.Image)
+	if imageErr == nil {
+		if imageName != name {
+			owner, err := store.GetContainer(userID, imageName)
+			if err != nil {
+				WriteError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if owner != nil {
+				WriteError(w, http.StatusConflict, "image belongs to another service")
+				return
+			}
+			// Mezclar dos historiales repetiría números de versión
+			own, err := store.GetLatestRelease(userID, name)
+			if err != nil {
+				WriteError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if own != nil {
+				WriteError(w, http.StatusConflict, "service name already has its own releases, deploy image "+name.String())
+				return
+			}
+		}
+		release, err = store.GetLatestRelease(userID, imageName)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	containerImage := payload.Image
+	if release != nil {
+		containerImage = release.Image
+	} else if strings.HasPrefix(containerImage, "svc-") {
+		WriteError(w, http.StatusForbidden, "image belongs to another service")
+		return
+	}
 
-	status, err := h.store.IsContainerRunning(payload.Image)
-	println("Container status:", status)
+	// Límites y cuotas antes de tocar Docker
+	plan, err := store.GetPlan(userID)
 	if err != nil {
-		WriteError(w, http.StatusConflict, err.Error())
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	limits, err := plan.Resolve(payload.Resources)
+	if err != nil {
+		writeQuotaError(w, err, http.StatusBadRequest)
+		return
+	}
+	releaseQuota, err := store.ReserveContainer(userID, plan, limits, name)
+	if err != nil {
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
+	defer releaseQuota()
 
-	time.Sleep(time.Second * 5)
+	handle, err := store.EnsureTenant(userID, GetEmailFromContext(r.Context()))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	spec, err := store.ServiceSpec(&ContainerRecord{UserID: userID, ContainerName: name, Resources: limits})
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	//-Logica del ENDPOINT------->
+	dockerName := dockerServiceName(userID, name)
+	err = store.NewContainer(containerImage, dockerName, spec)
+	if err != nil {
+		deploys.WithLabelValues("create", outcome(err)).Inc()
+		WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
 
-	if !status {
-		// Si el contenedor no está en ejecución, iniciarlo
-		WriteError(w, http.StatusBadRequest, "the container is not running after creation")
+	// Esperar a que el servicio pase la sonda de readiness
+	probeResult, err := store.WaitReady(dockerName, payload.Probe)
+	deploys.WithLabelValues("create", outcome(err)).Inc()
+	if err != nil {
+		if err := store.StopAndRemoveContainer(dockerName); err != nil {
+			deployLog.ErrorContext(r.Context(), "error eliminando contenedor no disponible", "dockerName", dockerName, "error", err)
+		}
+		WriteJSON(w, http.StatusBadRequest, map[string]any{
+			"error":  err.Error(),
+			"health": probeResult,
+		})
 		return
 	}
+	status := true
+
+	// Guardar el contenedor en MongoDB
+
+	// Solo las imágenes construidas aquí tienen un commit confiable
+	commitSHA := ""
+	if release != nil {
+		commitSHA = release.CommitSHA
+	}
 
 	record := ContainerRecord{
 		UserID:        userID,
-		ContainerName: payload.Image,
+		ContainerName: name,
+		DockerName:    dockerName,
+		Path:          servicePath(handle, name),
 		Status:        status,
 		CreatedAt:     time.Now(),
 		Description:   payload.Description,
 		Type:          payload.Type,
+		CommitSHA:     commitSHA,
+		Probe:         payload.Probe,
+		Resources:     limits,
+		Health: &HealthStatus{
+			Status:    "healthy",
+			CheckedAt: probeResult.CheckedAt,
+		},
+	}
+	if release != nil {
+		record.Release = release.Version
 	}
 
 	recordHistory := ContainerUpdate{
 		UserID:        userID,
-		ContainerName: payload.Image,
-		Status:        status,
+		ContainerName: name,
+		Event:         HistoryCreated,
+		Actor:         userID,
+		Release:       record.Release,
 		CreatedAt:     time.Now(),
 	}
 
-	_, err = h.store.SaveContainer(record)
+	_, err = store.SaveContainer(record)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to save container: "+err.Error())
 		return
 	}
 
-	_, err = h.store.SaveUpdate(recordHistory)
+	// Las releases quedan a nombre del servicio para que rollback y los builds
+	// siguientes las encuentren
+	if release != nil && imageName != name {
+		if err := store.RenameReleases(userID, imageName, name); err != nil {
+			WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		release.ContainerName = name
+	}
+	if release != nil {
+		if err := store.PromoteRelease(release); err != nil {
+			httpLog.ErrorContext(r.Context(), "error promoviendo la release", "error", err)
+		}
+	}
+
+	_, err = store.SaveUpdate(recordHistory)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to save container history: "+err.Error())
 		return
 	}
 
-	WriteJSON(w, http.StatusOK, o)
+	store.PublishEvent(userID, EventServiceDeployed, ServiceEvent{
+		ContainerName: name,
+		Release:       record.Release,
+		Image:         containerImage,
+		Status:        "running",
+		Actor:         userID,
+	})
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"image":       payload.Image,
+		"name":        name,
+		"type":        payload.Type,
+		"description": payload.Description,
+		"path":        record.Path,
+		"health":      probeResult,
+	})
 	//<----------------------
 
 }
 
 func (h *handler) HandleRemoveContainer(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
-	println("User ID from context:", userID)
 
 	var payload contenedorCreated
 
 	if err := ParseJSON(r, &payload); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -252,49 +438,88 @@ func (h *handler) HandleRemoveContainer(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = h.store.StopAndRemoveContainer(payload.Image)
+	name, err := ParseServiceName(payload.Image)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Solo se encuentran los contenedores del usuario
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
+
+	err = store.StopAndRemoveContainer(record.DockerName)
 	o := payload
 	if err != nil {
 		WriteError(w, http.StatusConflict, err.Error())
 		return
 	}
 
-	err = h.store.DeleteContainerDocument(userID, payload.Image)
+	err = store.DeleteContainerDocument(userID, name)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
 		return
 	}
 
+	if err := store.DeleteServiceConfig(userID, name); err != nil {
+		httpLog.ErrorContext(r.Context(), "error eliminando la configuración", "error", err)
+	}
+
+	// Los volúmenes se conservan; solo se desconectan
+	if err := store.DetachVolumes(userID, name); err != nil {
+		httpLog.ErrorContext(r.Context(), "error desconectando volúmenes", "error", err)
+	}
+
+	// Las imágenes del servicio quedan sin release y las elimina el GC de imágenes
+	if err := store.DeleteReleases(userID, name); err != nil {
+		httpLog.ErrorContext(r.Context(), "error eliminando releases", "error", err)
+	}
+
+	if err := store.PruneTenantNetworks(userID); err != nil {
+		httpLog.ErrorContext(r.Context(), "error limpiando redes", "error", err)
+	}
+
 	recordHistory := ContainerUpdate{
 		UserID:        userID,
-		ContainerName: payload.Image,
-		Status:        false,
+		ContainerName: name,
+		Event:         HistoryRemoved,
+		Actor:         userID,
 		CreatedAt:     time.Now(),
 	}
 
-	_, err = h.store.SaveUpdate(recordHistory)
+	_, err = store.SaveUpdate(recordHistory)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to save container history: "+err.Error())
 		return
 	}
 
+	store.PublishEvent(userID, EventServiceRemoved, ServiceEvent{ContainerName: name, Status: "removed", Actor: userID})
+
 	WriteJSON(w, http.StatusOK, o)
 
 }
 
 func (h *handler) HandleStopContainer(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
-	println("User ID from context:", userID)
 
 	var payload contenedorCreated
 
 	if err := ParseJSON(r, &payload); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -305,16 +530,31 @@ func (h *handler) HandleStopContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//validacion de propiedad
+	name, err := ParseServiceName(payload.Image)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Solo se encuentran los contenedores del usuario
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
 
-	err = h.store.StopContainer(payload.Image)
+	err = store.StopContainer(record.DockerName)
 	o := payload
 	if err != nil {
 		WriteError(w, http.StatusConflict, err.Error())
 		return
 	}
 
-	err = h.store.UpdateContainerStatus(userID, payload.Image, false)
+	err = store.UpdateContainerStatus(userID, name, false)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
 		return
@@ -322,34 +562,38 @@ func (h *handler) HandleStopContainer(w http.ResponseWriter, r *http.Request) {
 
 	recordHistory := ContainerUpdate{
 		UserID:        userID,
-		ContainerName: payload.Image,
-		Status:        false,
+		ContainerName: name,
+		Event:         HistoryStopped,
+		Actor:         userID,
 		CreatedAt:     time.Now(),
 	}
 
-	_, err = h.store.SaveUpdate(recordHistory)
+	_, err = store.SaveUpdate(recordHistory)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to save container history: "+err.Error())
 		return
 	}
 
+	store.PublishEvent(userID, EventServiceStopped, ServiceEvent{ContainerName: name, Status: "stopped", Actor: userID})
+
 	WriteJSON(w, http.StatusOK, o)
 
 }
 
 func (h *handler) HandleStartContainer(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
-	println("User ID from context:", userID)
 
 	var payload contenedorCreated
 
 	if err := ParseJSON(r, &payload); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -360,16 +604,31 @@ func (h *handler) HandleStartContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//validacion de propiedad
+	name, err := ParseServiceName(payload.Image)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Solo se encuentran los contenedores del usuario
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
 
-	err = h.store.StartContainer(payload.Image)
+	err = store.StartContainer(record.DockerName)
 	o := payload
 	if err != nil {
 		WriteError(w, http.StatusConflict, err.Error())
 		return
 	}
 
-	err = h.store.UpdateContainerStatus(userID, payload.Image, true)
+	err = store.UpdateContainerStatus(userID, name, true)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
 		return
@@ -377,437 +636,1994 @@ func (h *handler) HandleStartContainer(w http.ResponseWriter, r *http.Request) {
 
 	recordHistory := ContainerUpdate{
 		UserID:        userID,
-		ContainerName: payload.Image,
-		Status:        true,
+		ContainerName: name,
+		Event:         HistoryStarted,
+		Actor:         userID,
 		CreatedAt:     time.Now(),
 	}
 
-	_, err = h.store.SaveUpdate(recordHistory)
+	_, err = store.SaveUpdate(recordHistory)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "failed to save container history: "+err.Error())
 		return
 	}
 
+	store.PublishEvent(userID, EventServiceStarted, ServiceEvent{ContainerName: name, Status: "running", Actor: userID})
+
 	WriteJSON(w, http.StatusOK, o)
 
 }
 
 func (h *handler) HandleEditContainer(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
-	println("User ID from context:", userID)
-
-	fmt.Println("Recibido solicitud de creación de imagen")
 
 	// Limitar tamaño máximo de archivos
 	if err := r.ParseMultipartForm(20 << 20); err != nil { // 20 MB
-		log.Println("Error en ParseMultipartForm:", err)
+		httpLog.WarnContext(r.Context(), "invalid multipart form", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	fmt.Println("ParseMultipartForm exitoso")
 
 	// Leer nombre del servicio
-	name := r.FormValue("name")
-	if name == "" {
-		log.Println("Nombre del servicio es obligatorio")
-		WriteError(w, http.StatusBadRequest, "nombre del servicio es obligatorio")
+	name, err := ParseServiceName(r.FormValue("name"))
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "nombre del servicio inválido", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	buildLog.InfoContext(r.Context(), "solicitud de build", "containerName", name)
+
+	if err := store.CheckBuildQuota(userID); err != nil {
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("Nombre del servicio:", name)
 
-	isOwner, err := h.store.IsOwner(userID, name)
+	existing, err := store.GetContainer(userID, name)
 	if err != nil {
-		log.Fatal(err)
+		httpLog.ErrorContext(r.Context(), "error leyendo el contenedor", "error", err)
 		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if !isOwner {
-		log.Println("No eres el propietario del contenedor")
+	if existing == nil {
 		WriteError(w, http.StatusForbidden, "no eres el propietario del contenedor")
 		return
 	}
 
-	Type := r.FormValue("type")
-	if Type == "" {
-		log.Println("tipo del servicio es obligatorio")
-		WriteError(w, http.StatusBadRequest, "tipo del servicio es obligatorio")
-		return
+	// Sonda de readiness opcional; si no llega se conserva la anterior
+	probe := existing.Probe
+	if raw := r.FormValue("probe"); raw != "" {
+		probe = &HealthProbe{}
+		if err := json.Unmarshal([]byte(raw), probe); err != nil {
+			WriteError(w, http.StatusBadRequest, "probe debe ser un objeto JSON")
+			return
+		}
+		if err := probe.Validate(); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid probe: "+err.Error())
+			return
+		}
 	}
 
-	description := r.FormValue("description")
-	if description == "" {
-		log.Println("Nombre del servicio es obligatorio")
-		WriteError(w, http.StatusBadRequest, "nombre del servicio es obligatorio")
+	// Límites de recursos opcionales; si no llegan se conservan los anteriores
+	requested := &existing.Resources
+	if raw := r.FormValue("resources"); raw != "" {
+		requested = &ResourceLimits{}
+		if err := json.Unmarshal([]byte(raw), requested); err != nil {
+			WriteError(w, http.StatusBadRequest, "resources debe ser un objeto JSON")
+			return
+		}
+	}
+	plan, err := store.GetPlan(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	// Crear carpeta workspace
-	workspaceDir := "./workspace/" + name
-	os.MkdirAll(workspaceDir, os.ModePerm)
-
-	// Leer archivo app.py
-	appFileHeader, ok := r.MultipartForm.File["app"]
-	if !ok || len(appFileHeader) == 0 {
-		log.Println("Archivo app.py es obligatorio")
-		WriteError(w, http.StatusBadRequest, "archivo app.py es obligatorio")
+	limits, err := plan.Resolve(requested)
+	if err != nil {
+		writeQuotaError(w, err, http.StatusBadRequest)
 		return
 	}
-
-	appFile, err := appFileHeader[0].Open()
+	releaseQuota, err := store.ReserveContainer(userID, plan, limits, name)
 	if err != nil {
-		log.Printf("Error abriendo app.py: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo abrir app.py")
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
-	defer appFile.Close()
+	defer releaseQuota()
 
-	dstApp, err := os.Create(workspaceDir + "/app.py")
-	if err != nil {
-		log.Printf("Error creando app.py en workspace: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo guardar app.py")
+	Type := r.FormValue("type")
+	if Type == "" {
+		WriteError(w, http.StatusBadRequest, "tipo del servicio es obligatorio")
 		return
 	}
-	defer dstApp.Close()
-	io.Copy(dstApp, appFile)
 
-	// Copiar Dockerfile desde ./files
-	srcDocker, err := os.Open("./files/Dockerfile")
-	if err != nil {
-		log.Printf("Error abriendo Dockerfile por defecto: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo usar Dockerfile por defecto")
+	description := r.FormValue("description")
+	if description == "" {
+		WriteError(w, http.StatusBadRequest, "nombre del servicio es obligatorio")
 		return
 	}
-	defer srcDocker.Close()
 
-	dstDocker, err := os.Create(workspaceDir + "/Dockerfile")
+	// Workspace aislado por build
+	workspaceDir, err := newBuildWorkspace("build-")
 	if err != nil {
-		log.Printf("Error creando Dockerfile en workspace: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo copiar Dockerfile por defecto")
+		buildLog.ErrorContext(r.Context(), "error creando workspace", "error", err)
+		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	defer dstDocker.Close()
-	io.Copy(dstDocker, srcDocker)
+	defer os.RemoveAll(workspaceDir)
 
-	// Copiar server.py desde ./files
-	srcServer, err := os.Open("./files/server.py")
+	buildOpts, err := prepareBuildWorkspace(r, workspaceDir)
 	if err != nil {
-		log.Printf("Error abriendo server.py: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo copiar server.py")
+		buildLog.WarnContext(r.Context(), "error preparando workspace", "error", err)
+		if errors.Is(err, errInvalidBuildInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	defer srcServer.Close()
 
-	dstServer, err := os.Create(workspaceDir + "/server.py")
+	// Construir una nueva release desde workspace
+	release, err := store.BuildRelease(userID, name, workspaceDir, buildOpts)
 	if err != nil {
-		log.Printf("Error creando server.py en workspace: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo guardar server.py")
+		buildLog.ErrorContext(r.Context(), "error construyendo imagen", "error", err)
+		if errors.Is(err, errInvalidBuildInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
-	defer dstServer.Close()
-	io.Copy(dstServer, srcServer)
 
-	// Construir imagen desde workspace
-	imageName := name + ":latest"
-	if err := h.store.BuildContainerImage(workspaceDir, imageName); err != nil {
-		fmt.Printf("Error al construir imagen: %v", err)
+	// Reemplazo blue/green: si la nueva versión falla, la anterior sigue corriendo
+	spec, err := store.ServiceSpec(&ContainerRecord{UserID: userID, ContainerName: name, Resources: limits, Security: existing.Security})
+	if err != nil {
 		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	err = h.store.UpdateContainerInfo(
-		userID,
-		name,
-		Type,
-		description,
-	)
+	probeResult, err := store.Redeploy(release.Image, existing.DockerName, spec, probe)
 	if err != nil {
-		log.Println(err)
-	} else {
-		fmt.Println("Container updated successfully!")
+		deployLog.ErrorContext(r.Context(), "error desplegando", "image", release.Image, "error", err)
+		store.recordHistory(ContainerUpdate{
+			UserID:        userID,
+			ContainerName: name,
+			Event:         HistoryEditFailed,
+			Actor:         userID,
+			Reason:        err.Error(),
+			Release:       release.Version,
+		})
+		WriteJSON(w, http.StatusConflict, map[string]any{
+			"error":  err.Error(),
+			"health": probeResult,
+		})
+		return
 	}
 
-	err = h.store.StopAndRemoveContainer(name)
-	if err != nil {
-		WriteError(w, http.StatusConflict, err.Error())
-		return
+	// Solo con la nueva versión atendiendo se guardan los cambios y se mueve :latest
+	if err := store.PromoteRelease(release); err != nil {
+		httpLog.ErrorContext(r.Context(), "error promoviendo la release", "error", err)
 	}
 
-	err = h.store.NewContainer(name, name)
+	err = store.UpdateContainerStatus(userID, name, true)
 	if err != nil {
-		WriteError(w, http.StatusConflict, err.Error())
+		WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
 		return
 	}
 
-	time.Sleep(time.Second * 5)
+	if err := store.UpdateContainerInfo(userID, name, Type, description); err != nil {
+		httpLog.ErrorContext(r.Context(), "error actualizando el contenedor", "error", err)
+	}
 
-	status, err := h.store.IsContainerRunning(name)
-	println("Container status:", status)
-	if err != nil {
-		WriteError(w, http.StatusConflict, err.Error())
-		return
+	if err := store.UpdateContainerRelease(userID, name, release); err != nil {
+		httpLog.ErrorContext(r.Context(), "error guardando la release del contenedor", "error", err)
 	}
 
-	if !status {
-		WriteError(w, http.StatusBadRequest, "the container is not running after creation")
-		err = h.store.UpdateContainerStatus(userID, name, true)
-		if err != nil {
-			WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
-			return
-		}
-		return
+	if err := store.UpdateContainerProbe(userID, name, probe); err != nil {
+		httpLog.ErrorContext(r.Context(), "error guardando la sonda", "error", err)
 	}
 
-	err = h.store.UpdateContainerStatus(userID, name, true)
-	if err != nil {
-		WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
-		return
+	if err := store.UpdateContainerResources(userID, name, limits); err != nil {
+		httpLog.ErrorContext(r.Context(), "error guardando los recursos", "error", err)
 	}
 
-	WriteJSON(w, http.StatusOK, "running")
+	store.recordHistory(ContainerUpdate{
+		UserID:        userID,
+		ContainerName: name,
+		Event:         HistoryEdited,
+		Actor:         userID,
+		Release:       release.Version,
+	})
+
+	store.PublishEvent(userID, EventServiceEdited, ServiceEvent{
+		ContainerName: name,
+		Release:       release.Version,
+		Image:         release.Image,
+		Status:        "running",
+		Actor:         userID,
+	})
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"status":  "running",
+		"release": release.Version,
+		"health":  probeResult,
+	})
 
 }
 
 func (h *handler) HandleImageCreation(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
-	println("User ID from context:", userID)
-
-	fmt.Println("Recibido solicitud de creación de imagen")
 
 	// Limitar tamaño máximo de archivos
 	if err := r.ParseMultipartForm(20 << 20); err != nil { // 20 MB
-		log.Println("Error en ParseMultipartForm:", err)
+		httpLog.WarnContext(r.Context(), "invalid multipart form", "error", err)
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	fmt.Println("ParseMultipartForm exitoso")
 
 	// Leer nombre del servicio
-	name := r.FormValue("name")
-	if name == "" {
-		log.Println("Nombre del servicio es obligatorio")
-		WriteError(w, http.StatusBadRequest, "nombre del servicio es obligatorio")
+	name, err := ParseServiceName(r.FormValue("name"))
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "nombre del servicio inválido", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	buildLog.InfoContext(r.Context(), "solicitud de build", "containerName", name)
+
+	if err := store.CheckBuildQuota(userID); err != nil {
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("Nombre del servicio:", name)
 
-	exists, err := h.store.ContainerExists(name)
+	existing, err := store.GetContainer(userID, name)
 	if err != nil {
-		log.Printf("Error checking container existence: %v", err)
+		httpLog.ErrorContext(r.Context(), "error checking container existence", "error", err)
 		WriteError(w, http.StatusInternalServerError, "error checking container existence")
 		return
 	}
-	if exists {
-		log.Printf("Container with name %s already exists", name)
+	if existing != nil {
 		WriteError(w, http.StatusConflict, "container with this name already exists")
 		return
 	}
 
-	// Crear carpeta workspace
-	workspaceDir := "./workspace/" + name
-	os.MkdirAll(workspaceDir, os.ModePerm)
-
-	// Leer archivo app.py
-	appFileHeader, ok := r.MultipartForm.File["app"]
-	if !ok || len(appFileHeader) == 0 {
-		log.Println("Archivo app.py es obligatorio")
-		WriteError(w, http.StatusBadRequest, "archivo app.py es obligatorio")
+	// Workspace aislado por build
+	workspaceDir, err := newBuildWorkspace("build-")
+	if err != nil {
+		buildLog.ErrorContext(r.Context(), "error creando workspace", "error", err)
+		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer os.RemoveAll(workspaceDir)
 
-	appFile, err := appFileHeader[0].Open()
+	buildOpts, err := prepareBuildWorkspace(r, workspaceDir)
 	if err != nil {
-		log.Printf("Error abriendo app.py: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo abrir app.py")
+		buildLog.WarnContext(r.Context(), "error preparando workspace", "error", err)
+		if errors.Is(err, errInvalidBuildInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	defer appFile.Close()
 
-	dstApp, err := os.Create(workspaceDir + "/app.py")
+	// Construir imagen desde workspace
+	imageName := dockerServiceName(userID, name) + ":latest"
+	release, err := store.BuildRelease(userID, name, workspaceDir, buildOpts)
 	if err != nil {
-		log.Printf("Error creando app.py en workspace: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo guardar app.py")
+		buildLog.ErrorContext(r.Context(), "error construyendo imagen", "error", err)
+		if errors.Is(err, errInvalidBuildInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
-	defer dstApp.Close()
-	io.Copy(dstApp, appFile)
 
-	// Copiar Dockerfile desde ./files
-	srcDocker, err := os.Open("./files/Dockerfile")
+	if err := store.PromoteIfUndeployed(release); err != nil {
+		httpLog.ErrorContext(r.Context(), "error promoviendo la release", "error", err)
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"image":   imageName,
+		"release": release.Image,
+		"version": release.Version,
+	})
+}
+
+func (h *handler) HandleGitBuild(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Error abriendo Dockerfile por defecto: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo usar Dockerfile por defecto")
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
-	defer srcDocker.Close()
 
-	dstDocker, err := os.Create(workspaceDir + "/Dockerfile")
-	if err != nil {
-		log.Printf("Error creando Dockerfile en workspace: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo copiar Dockerfile por defecto")
+	var payload gitBuild
+
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		formattedErrors := FormatValidationErrors(errors)
+		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
 		return
 	}
-	defer dstDocker.Close()
-	io.Copy(dstDocker, srcDocker)
 
-	// Copiar server.py desde ./files
-	srcServer, err := os.Open("./files/server.py")
-	if err != nil {
-		log.Printf("Error abriendo server.py: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo copiar server.py")
+	if err := store.CheckBuildQuota(userID); err != nil {
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
-	defer srcServer.Close()
 
-	dstServer, err := os.Create(workspaceDir + "/server.py")
+	name, err := ParseServiceName(payload.Name)
 	if err != nil {
-		log.Printf("Error creando server.py en workspace: %v", err)
-		WriteError(w, http.StatusInternalServerError, "no se pudo guardar server.py")
+		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer dstServer.Close()
-	io.Copy(dstServer, srcServer)
 
-	// Construir imagen desde workspace
-	imageName := name + ":latest"
-	if err := h.store.BuildContainerImage(workspaceDir, imageName); err != nil {
-		fmt.Printf("Error al construir imagen: %v", err)
+	// Workspace aislado por build
+	buildDir, err := newBuildWorkspace("git-")
+	if err != nil {
 		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer os.RemoveAll(buildDir)
 
-	WriteJSON(w, http.StatusOK, map[string]string{"image": imageName})
-}
+	repoDir := filepath.Join(buildDir, "repo")
+	commitSHA, err := store.cloneGitRepo(payload.Repo, payload.Ref, repoDir)
+	if err != nil {
+		buildLog.WarnContext(r.Context(), "error clonando repositorio", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-func (h *handler) HandleListUserContainers(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromContext(r.Context())
+	contextDir, err := resolveSubdir(repoDir, payload.Subdir)
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
-		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := prepareGitContext(contextDir); err != nil {
+		if errors.Is(err, errInvalidBuildInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	println("User ID from context:", userID)
+	buildOpts := BuildOptions{
+		Target: payload.Target,
+		Labels: map[string]string{commitLabel: commitSHA},
+	}
+	if len(payload.BuildArgs) > 0 {
+		buildOpts.BuildArgs = make(map[string]*string, len(payload.BuildArgs))
+		for k, v := range payload.BuildArgs {
+			buildOpts.BuildArgs[k] = &v
+		}
+	}
 
-	records, err := h.store.GetContainersByUser(userID)
+	imageName := dockerServiceName(userID, name) + ":latest"
+	release, err := store.BuildRelease(userID, name, contextDir, buildOpts)
 	if err != nil {
-		WriteError(w, http.StatusInternalServerError, "failed to fetch containers: "+err.Error())
+		buildLog.ErrorContext(r.Context(), "error construyendo imagen", "error", err)
+		if errors.Is(err, errInvalidBuildInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeQuotaError(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	if err := store.PromoteIfUndeployed(release); err != nil {
+		httpLog.ErrorContext(r.Context(), "error promoviendo la release", "error", err)
+	}
+
 	WriteJSON(w, http.StatusOK, map[string]any{
-		"containers": records,
-		"count":      len(records),
+		"image":   imageName,
+		"release": release.Image,
+		"version": release.Version,
+		"commit":  commitSHA,
 	})
-
 }
 
-func (h *handler) HandleListUserHistory(w http.ResponseWriter, r *http.Request) {
+func (h *handler) HandleGetContainer(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
 
-	println("User ID from context:", userID)
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	records, err := h.store.GetHistoryByUser(userID)
+	record, err := store.GetContainer(userID, name)
 	if err != nil {
-		WriteError(w, http.StatusInternalServerError, "failed to fetch containers: "+err.Error())
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
 		return
 	}
 
 	WriteJSON(w, http.StatusOK, map[string]any{
-		"containers": records,
-		"count":      len(records),
+		"container": record,
+		"security":  EffectiveSecurity(record.Security),
 	})
+}
+
+// HandleSetSecurityExceptions reemplaza las excepciones de seguridad de un
+// contenedor de cualquier usuario. Un body vacío ({}) las elimina.
+func (h *handler) HandleSetSecurityExceptions(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	adminID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	var payload SecurityExceptions
+
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := payload.Validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := r.PathValue("userId")
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
+
+	var exceptions *SecurityExceptions
+	if len(payload.CapAdd) > 0 || payload.WritableRootfs || payload.ImageUser {
+		payload.GrantedBy = adminID
+		payload.GrantedAt = time.Now()
+		exceptions = &payload
+	}
+
+	if err := store.UpdateContainerSecurity(userID, name, exceptions); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpLog.InfoContext(r.Context(), "excepciones de seguridad actualizadas", "userId", userID, "containerName", name, "adminId", adminID)
+
+	record.Security = exceptions
+	response := map[string]any{
+		"security":  EffectiveSecurity(exceptions),
+		"restarted": false,
+	}
+
+	if record.Status {
+		probeResult, err := store.RestartWithConfig(record)
+		response["health"] = probeResult
+		if err != nil {
+			response["error"] = err.Error()
+			WriteJSON(w, http.StatusConflict, response)
+			return
+		}
+		response["restarted"] = true
+	}
 
+	WriteJSON(w, http.StatusOK, response)
 }
 
-func (h *handler) HandleListUserHistoryGraphic(w http.ResponseWriter, r *http.Request) {
+func (h *handler) HandleListReleases(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
 	userID, err := GetUserIDFromContext(r.Context())
 	if err != nil {
-		log.Printf("Unauthorized access: %v", err)
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
 		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
 
-	println("User ID from context:", userID)
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
 
-	records, err := h.store.GetHistoryByUser(userID)
+	releases, err := store.GetReleases(userID, name)
 	if err != nil {
-		WriteError(w, http.StatusInternalServerError, "failed to fetch containers: "+err.Error())
+		WriteError(w, http.StatusInternalServerError, "failed to fetch releases: "+err.Error())
 		return
 	}
 
-	const days = 30
-	loc := time.Local
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"releases": releases,
+		"current":  record.Release,
+		"count":    len(releases),
+	})
+}
+
+func (h *handler) HandleRollback(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
 
-	type agg struct{ dep, err int }
-	byDay := make(map[string]agg)
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
 
-	for _, rec := range records {
-		t := rec.CreatedAt.In(loc)
-		dayKey := t.Format("2006-01-02")
+	var payload rollbackRequest
 
-		a := byDay[dayKey]
-		if rec.Status {
-			a.dep++
-		} else {
-			a.err++
-		}
-		byDay[dayKey] = a
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		formattedErrors := FormatValidationErrors(errors)
+		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusForbidden, "no eres el propietario del contenedor")
+		return
 	}
 
-	monthEs := [...]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"}
+	release, err := store.GetRelease(userID, name, payload.Version)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if release == nil {
+		WriteError(w, http.StatusNotFound, fmt.Sprintf("release %d not found", payload.Version))
+		return
+	}
 
-	labels := make([]string, 0, days)
-	deployments := make([]int, 0, days)
-	errorsArr := make([]int, 0, days)
+	available, err := store.ImageExists(release.Image)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !available {
+		WriteError(w, http.StatusGone, fmt.Sprintf("image %s is no longer available", release.Image))
+		return
+	}
 
-	today := time.Now().In(loc)
-	for i := days - 1; i >= 0; i-- {
-		d := today.AddDate(0, 0, -i)
-		key := d.Format("2006-01-02")
+	spec, err := store.ServiceSpec(record)
+	if err != nil {
+		writeQuotaError(w, err, http.StatusInternalServerError)
+		return
+	}
 
-		lbl := fmt.Sprintf("%d %s", d.Day(), monthEs[int(d.Month())-1])
-		labels = append(labels, lbl)
+	probeResult, deployErr := store.Redeploy(release.Image, record.DockerName, spec, record.Probe)
 
-		a := byDay[key]
-		deployments = append(deployments, a.dep)
-		errorsArr = append(errorsArr, a.err)
+	recordHistory := ContainerUpdate{
+		UserID:        userID,
+		ContainerName: name,
+		Event:         HistoryDeployed,
+		Actor:         userID,
+		Reason:        "rollback",
+		Release:       release.Version,
+		CreatedAt:     time.Now(),
+	}
+	if deployErr != nil {
+		recordHistory.Event = HistoryDeployFailed
+		recordHistory.Reason = "rollback: " + deployErr.Error()
+	}
+
+	_, err = store.SaveUpdate(recordHistory)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to save container history: "+err.Error())
+		return
+	}
+
+	if deployErr != nil {
+		WriteJSON(w, http.StatusConflict, map[string]any{
+			"error":  deployErr.Error(),
+			"health": probeResult,
+		})
+		return
+	}
+
+	if err := store.UpdateContainerStatus(userID, name, true); err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to update container status: "+err.Error())
+		return
+	}
+
+	if err := store.PromoteRelease(release); err != nil {
+		httpLog.ErrorContext(r.Context(), "error promoviendo la release", "error", err)
+	}
+
+	if err := store.UpdateContainerRelease(userID, name, release); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
+	store.PublishEvent(userID, EventServiceDeployed, ServiceEvent{
+		ContainerName: name,
+		Release:       release.Version,
+		Image:         release.Image,
+		Status:        "running",
+		Actor:         userID,
+	})
+
 	WriteJSON(w, http.StatusOK, map[string]any{
-		"labels":      labels,
-		"deployments": deployments,
-		"errors":      errorsArr,
+		"release": release,
+		"health":  probeResult,
 	})
 }
 
-func (h *handler) HandleGetLastHistory(w http.ResponseWriter, r *http.Request) {
-	record, err := h.store.GetLastHistory()
+func (h *handler) HandleGetEnv(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
+
+	cfg, err := store.GetServiceConfig(userID, name)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// De los secretos solo se devuelven los nombres
+	secrets := make([]string, 0, len(cfg.Secrets))
+	for key := range cfg.Secrets {
+		secrets = append(secrets, key)
+	}
+	sort.Strings(secrets)
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"vars":      cfg.Vars,
+		"secrets":   secrets,
+		"updatedAt": cfg.UpdatedAt,
+	})
+}
+
+func (h *handler) HandleSetEnv(w http.ResponseWriter, r *http.Request) {
+	h.handleConfigChange(w, r, false, true)
+}
+
+func (h *handler) HandleDeleteEnv(w http.ResponseWriter, r *http.Request) {
+	h.handleConfigChange(w, r, false, false)
+}
+
+func (h *handler) HandleSetSecret(w http.ResponseWriter, r *http.Request) {
+	h.handleConfigChange(w, r, true, true)
+}
+
+func (h *handler) HandleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	h.handleConfigChange(w, r, true, false)
+}
+
+// handleConfigChange guarda o elimina una variable o secreto y, si el contenedor
+// está corriendo, lo reemplaza para que tome la nueva configuración.
+func (h *handler) handleConfigChange(w http.ResponseWriter, r *http.Request, secret, set bool) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key := r.PathValue("key")
+	if err := ValidateEnvKey(key); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if record == nil {
-		WriteJSON(w, http.StatusOK, map[string]any{
-			"message": "no history records found",
+		WriteError(w, http.StatusForbidden, "no eres el propietario del contenedor")
+		return
+	}
+
+	// Valor guardado antes del cambio, para restaurarlo si el reinicio falla
+	current, err := store.GetServiceConfig(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	values := current.Vars
+	if secret {
+		values = current.Secrets
+	}
+	var previous *string
+	if v, ok := values[key]; ok {
+		previous = &v
+	}
+
+	switch {
+	case set:
+		var payload envValueRequest
+		if err := ParseJSON(r, &payload); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if secret {
+			err = store.SetSecret(userID, name, key, payload.Value)
+		} else {
+			err = store.SetConfigVar(userID, name, key, payload.Value)
+		}
+	default:
+		var found bool
+		found, err = store.DeleteConfigKey(userID, name, key, secret)
+		if err == nil && !found {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("%s not found", key))
+			return
+		}
+	}
+	if err != nil {
+		if errors.Is(err, errSecretsDisabled) {
+			WriteError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := map[string]any{
+		"key":       key,
+		"secret":    secret,
+		"restarted": false,
+	}
+
+	if record.Status {
+		probeResult, err := store.RestartWithConfig(record)
+		response["health"] = probeResult
+		if err != nil {
+			deployLog.ErrorContext(r.Context(), "error reiniciando", "containerName", name, "error", err)
+			if err := store.RestoreConfigKey(userID, name, key, secret, previous); err != nil {
+				httpLog.ErrorContext(r.Context(), "error restaurando la configuración", "key", key, "error", err)
+			}
+			response["error"] = err.Error()
+			WriteJSON(w, http.StatusConflict, response)
+			return
+		}
+		response["restarted"] = true
+
+		store.recordHistory(ContainerUpdate{
+			UserID:        userID,
+			ContainerName: name,
+			Event:         HistoryRestarted,
+			Actor:         userID,
+			Reason:        "configuration changed: " + key,
+			Release:       record.Release,
 		})
+	}
+
+	WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) HandleCreateVolume(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
 		return
 	}
 
-	WriteJSON(w, http.StatusOK, record)
+	var payload volumeRequest
+
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		formattedErrors := FormatValidationErrors(errors)
+		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
+		return
+	}
+
+	volume, err := store.CreateVolume(userID, payload.Name, payload.SizeMB)
+	if err != nil {
+		if errors.Is(err, errInvalidVolume) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, errVolumeSize) {
+			WriteError(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		writeQuotaError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, http.StatusCreated, volume)
+}
+
+func (h *handler) HandleListVolumes(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	volumes, err := store.GetVolumes(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, volumes)
+}
+
+func (h *handler) HandleDeleteVolume(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	volume, err := store.GetVolume(userID, r.PathValue("volume"))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if volume == nil {
+		WriteError(w, http.StatusNotFound, "volume not found")
+		return
+	}
+	if volume.Attachment != nil {
+		WriteError(w, http.StatusConflict, fmt.Sprintf("volume is attached to %s, detach it first", volume.Attachment.ContainerName))
+		return
+	}
+
+	if err := store.DeleteVolume(volume); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]string{"deleted": volume.Name})
+}
+
+func (h *handler) HandleAttachVolume(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	var payload attachVolumeRequest
+
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		formattedErrors := FormatValidationErrors(errors)
+		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
+		return
+	}
+
+	mountPath, err := validateMountPath(payload.MountPath)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusForbidden, "no eres el propietario del contenedor")
+		return
+	}
+
+	// Solo se buscan volúmenes del mismo usuario
+	volume, err := store.GetVolume(userID, payload.Volume)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if volume == nil {
+		WriteError(w, http.StatusNotFound, "volume not found")
+		return
+	}
+	if volume.Attachment != nil && volume.Attachment.ContainerName != name {
+		WriteError(w, http.StatusConflict, fmt.Sprintf("volume is attached to %s", volume.Attachment.ContainerName))
+		return
+	}
+
+	mounts, err := store.ContainerMounts(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, m := range mounts {
+		if m.Target == mountPath && m.Source != volume.DockerName {
+			WriteError(w, http.StatusConflict, fmt.Sprintf("%s already has a volume mounted", mountPath))
+			return
+		}
+	}
+
+	if err := store.PrepareVolume(volume, EffectiveSecurity(record.Security)); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	attachment := &VolumeAttachment{
+		ContainerName: name,
+		MountPath:     mountPath,
+		ReadOnly:      payload.ReadOnly,
+	}
+	if err := store.SetVolumeAttachment(userID, volume.Name, attachment); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.restartForVolume(w, r, userID, record, volume, volume.Attachment)
+}
+
+func (h *handler) HandleDetachVolume(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusForbidden, "no eres el propietario del contenedor")
+		return
+	}
+
+	volume, err := store.GetVolume(userID, r.PathValue("volume"))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if volume == nil || volume.Attachment == nil || volume.Attachment.ContainerName != name {
+		WriteError(w, http.StatusNotFound, "volume is not attached to this container")
+		return
+	}
+
+	if err := store.SetVolumeAttachment(userID, volume.Name, nil); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.restartForVolume(w, r, userID, record, volume, volume.Attachment)
+}
+
+// restartForVolume reemplaza el contenedor para aplicar el cambio de volúmenes.
+// Si el nuevo contenedor falla se restaura la conexión anterior (previous).
+func (h *handler) restartForVolume(w http.ResponseWriter, r *http.Request, userID string, record *ContainerRecord, volume *Volume, previous *VolumeAttachment) {
+	store := h.store.WithContext(r.Context())
+
+	response := map[string]any{
+		"volume":    volume.Name,
+		"restarted": false,
+	}
+
+	if record.Status {
+		probeResult, err := store.RestartWithConfig(record)
+		response["health"] = probeResult
+		if err != nil {
+			deployLog.ErrorContext(r.Context(), "error reiniciando", "containerName", record.ContainerName, "error", err)
+			if err := store.SetVolumeAttachment(userID, volume.Name, previous); err != nil {
+				httpLog.ErrorContext(r.Context(), "error restaurando el volumen", "error", err)
+			}
+			response["error"] = err.Error()
+			WriteJSON(w, http.StatusConflict, response)
+			return
+		}
+		response["restarted"] = true
+	}
+
+	updated, err := store.GetVolume(userID, volume.Name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response["volume"] = updated
+
+	WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) HandleListUserContainers(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	query, err := ParseListQuery(r.URL.Query(), containerSorts)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Filter, err = ContainerListFilter(userID, r.URL.Query())
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := store.ListContainerRecords(query)
+	if err != nil {
+		if errors.Is(err, errInvalidListQuery) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "failed to fetch containers: "+err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"containers": page.Items,
+		"count":      len(page.Items),
+		"total":      page.Total,
+		"nextCursor": page.NextCursor,
+	})
+
+}
+
+func (h *handler) HandleListUserHistory(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	query, err := ParseListQuery(r.URL.Query(), historySorts)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Filter, err = HistoryListFilter(userID, r.URL.Query())
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := store.ListHistoryEntries(query)
+	if err != nil {
+		if errors.Is(err, errInvalidListQuery) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "failed to fetch containers: "+err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"containers": page.Items,
+		"count":      len(page.Items),
+		"total":      page.Total,
+		"nextCursor": page.NextCursor,
+	})
+
+}
+
+var monthsEs = [...]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"}
+
+func (h *handler) HandleListUserHistoryGraphic(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	const days = 30
+	loc := time.Local
+
+	today := time.Now().In(loc)
+	since := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -(days - 1))
+	byDay, err := store.CountHistoryByDay(userID, since, loc)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch containers: "+err.Error())
+		return
+	}
+
+	labels := make([]string, 0, days)
+	deployments := make([]int, 0, days)
+	errorsArr := make([]int, 0, days)
+
+	for i := days - 1; i >= 0; i-- {
+		d := today.AddDate(0, 0, -i)
+		key := d.Format("2006-01-02")
+
+		lbl := fmt.Sprintf("%d %s", d.Day(), monthsEs[int(d.Month())-1])
+		labels = append(labels, lbl)
+
+		a := byDay[key]
+		deployments = append(deployments, a.Deployments)
+		errorsArr = append(errorsArr, a.Errors)
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"labels":      labels,
+		"deployments": deployments,
+		"errors":      errorsArr,
+	})
+}
+
+func (h *handler) HandleGetUsage(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	plan, err := store.GetPlan(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	usage, err := store.GetUsage(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"plan":  plan,
+		"usage": usage,
+		"limits": map[string]any{
+			"containers":  plan.MaxContainers,
+			"memoryMb":    plan.MaxTotalMemoryMB,
+			"buildsToday": plan.MaxBuildsPerDay,
+			"volumes":     plan.MaxVolumes,
+			"volumeMb":    plan.MaxVolumeMB,
+		},
+	})
+}
+
+func (h *handler) HandleGetNetworkPolicy(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	policy, err := store.GetNetworkPolicy(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, policy)
+}
+
+// HandleUpdateNetworkPolicy guarda la política y reemplaza los servicios en
+// ejecución para moverlos a la red que corresponde.
+func (h *handler) HandleUpdateNetworkPolicy(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	var payload networkPolicyRequest
+
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	policy, err := store.GetNetworkPolicy(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if payload.Egress != nil {
+		policy.Egress = *payload.Egress
+	}
+	if payload.ServiceToService != nil {
+		policy.ServiceToService = *payload.ServiceToService
+	}
+
+	if err := store.SaveNetworkPolicy(policy); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Por páginas, para no cargar todos los servicios del usuario a la vez
+	failed := map[ServiceName]string{}
+	redeployed := []ServiceName{}
+	err = store.EachRunningContainer(userID, func(rec ContainerRecord) error {
+		if _, err := store.RestartWithConfig(&rec); err != nil {
+			networkLog.ErrorContext(r.Context(), "error moviendo el contenedor de red", "containerName", rec.ContainerName, "error", err)
+			failed[rec.ContainerName] = err.Error()
+			return nil
+		}
+		redeployed = append(redeployed, rec.ContainerName)
+		return nil
+	})
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := store.PruneTenantNetworks(userID); err != nil {
+		httpLog.ErrorContext(r.Context(), "error limpiando redes", "error", err)
+	}
+
+	status := http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusConflict
+	}
+	WriteJSON(w, status, map[string]any{
+		"policy":     policy,
+		"redeployed": redeployed,
+		"failed":     failed,
+	})
+}
+
+func (h *handler) HandleGetLastHistory(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	record, err := store.GetLastHistory()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if record == nil {
+		WriteJSON(w, http.StatusOK, map[string]any{
+			"message": "no history records found",
+		})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, record)
+}
+
+// HandleImageGCReport calcula qué eliminaría el GC de imágenes, sin borrar nada
+func (h *handler) HandleImageGCReport(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	report, err := store.CollectImages(true)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, report)
+}
+
+// HandleContainerLogs devuelve los logs del contenedor. Con "Accept: text/event-stream"
+// cada línea es un evento SSE "stdout" o "stderr" y el flujo cierra con "end"; si
+// no, es texto plano con transferencia chunked y ambos flujos mezclados.
+func (h *handler) HandleContainerLogs(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, err := parseLogOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Solo se encuentran los contenedores del usuario
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	logs, err := store.ContainerLogs(ctx, record.DockerName, opts)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			WriteError(w, http.StatusNotFound, "container is not deployed")
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer logs.Close()
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+
+		out := flushWriter{w: w, rc: http.NewResponseController(w)}
+		if _, err := stdcopy.StdCopy(out, out, logs); err != nil && ctx.Err() == nil {
+			httpLog.WarnContext(r.Context(), "error enviando logs", "dockerName", record.DockerName, "error", err)
+		}
+		return
+	}
+
+	stream := newSSEStream(w)
+	if opts.Follow {
+		go stream.KeepAlive(ctx, logKeepAlive)
+	}
+
+	stdout := &sseLineWriter{stream: stream, event: "stdout"}
+	stderr := &sseLineWriter{stream: stream, event: "stderr"}
+	_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	if ctx.Err() != nil {
+		return
+	}
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		stream.Event("error", err.Error())
+	}
+	// Sin este evento EventSource vuelve a conectarse al terminar el flujo
+	stream.Event("end", "")
+}
+
+// HandleContainerStats devuelve el consumo actual del contenedor. Con stream=true
+// manda una muestra por segundo: eventos SSE "stats" si se pide text/event-stream,
+// si no, un objeto JSON por línea (NDJSON).
+func (h *handler) HandleContainerStats(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stream, err := parseBoolParam("stream", r.URL.Query().Get("stream"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Solo se encuentran los contenedores del usuario
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
+	if !record.Status {
+		WriteError(w, http.StatusConflict, "container is not running")
+		return
+	}
+
+	ctx := r.Context()
+
+	if !stream {
+		var sample *ResourceStats
+		err := store.ContainerStats(ctx, record.DockerName, false, func(stats ResourceStats) error {
+			sample = &stats
+			return nil
+		})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				WriteError(w, http.StatusNotFound, "container is not deployed")
+				return
+			}
+			WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if sample == nil {
+			WriteError(w, http.StatusConflict, "container is not running")
+			return
+		}
+		WriteJSON(w, http.StatusOK, sample)
+		return
+	}
+
+	var send func(ResourceStats) error
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		sse := newSSEStream(w)
+		send = func(stats ResourceStats) error {
+			data, err := json.Marshal(stats)
+			if err != nil {
+				return err
+			}
+			return sse.Event("stats", string(data))
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(flushWriter{w: w, rc: http.NewResponseController(w)})
+		send = func(stats ResourceStats) error {
+			return enc.Encode(stats)
+		}
+	}
+
+	// Las cabeceras ya se enviaron; un error solo puede registrarse
+	if err := store.ContainerStats(ctx, record.DockerName, true, send); err != nil && ctx.Err() == nil {
+		httpLog.WarnContext(r.Context(), "error enviando stats", "dockerName", record.DockerName, "error", err)
+	}
+}
+
+// HandleContainerMetrics devuelve el historial de consumo del servicio entre from y
+// to (por defecto, las últimas 24 horas) en intervalos de step, con el mismo
+// formato de labels y series que /containers/graphic. Los intervalos sin muestras
+// son null.
+func (h *handler) HandleContainerMetrics(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	name, err := ParseServiceName(r.PathValue("name"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	now := time.Now()
+	to, err := parseMetricsTime(q.Get("to"), now, now)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "to: "+err.Error())
+		return
+	}
+	from, err := parseMetricsTime(q.Get("from"), now, to.Add(-24*time.Hour))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "from: "+err.Error())
+		return
+	}
+	if !from.Before(to) {
+		WriteError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	step := defaultMetricsStep(to.Sub(from))
+	if raw := q.Get("step"); raw != "" {
+		if step, err = time.ParseDuration(raw); err != nil {
+			WriteError(w, http.StatusBadRequest, "step must be a duration like 5m or 1h")
+			return
+		}
+	}
+	if step < metricsSampleInterval {
+		step = metricsSampleInterval
+	}
+	if step < time.Second || to.Sub(from)/step > maxMetricsPoints {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("step is too small for the range (max %d points)", maxMetricsPoints))
+		return
+	}
+
+	record, err := store.GetContainer(userID, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if record == nil {
+		WriteError(w, http.StatusNotFound, "container not found")
+		return
+	}
+
+	buckets, err := store.GetMetrics(userID, name, from, to, step)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byStart := make(map[int64]metricBucket, len(buckets))
+	for _, b := range buckets {
+		byStart[b.Start] = b
+	}
+
+	stepMs := step.Milliseconds()
+	first := from.UnixMilli() - from.UnixMilli()%stepMs
+
+	var (
+		labels    []string
+		cpu       []*float64
+		memoryMB  []*float64
+		networkRx []*int64
+		networkTx []*int64
+		prev      *metricBucket
+	)
+	for start := first; start < to.UnixMilli(); start += stepMs {
+		t := time.UnixMilli(start).In(time.Local)
+		label := fmt.Sprintf("%d %s", t.Day(), monthsEs[int(t.Month())-1])
+		if step < 24*time.Hour {
+			label += t.Format(" 15:04")
+		}
+		labels = append(labels, label)
+
+		b, ok := byStart[start]
+		if !ok {
+			cpu, memoryMB = append(cpu, nil), append(memoryMB, nil)
+			networkRx, networkTx = append(networkRx, nil), append(networkTx, nil)
+			prev = nil
+			continue
+		}
+
+		c := math.Round(b.CPU*100) / 100
+		m := math.Round(b.Memory/(1<<20)*100) / 100
+		// Con el intervalo anterior se cuenta también lo transferido entre las dos muestras
+		rx, tx := b.RxMax-b.RxMin, b.TxMax-b.TxMin
+		if prev != nil {
+			rx, tx = counterDelta(prev.RxMax, b.RxMax), counterDelta(prev.TxMax, b.TxMax)
+		}
+		cpu, memoryMB = append(cpu, &c), append(memoryMB, &m)
+		networkRx, networkTx = append(networkRx, &rx), append(networkTx, &tx)
+		prev = &b
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"labels":         labels,
+		"cpuPercent":     cpu,
+		"memoryMb":       memoryMB,
+		"networkRxBytes": networkRx,
+		"networkTxBytes": networkTx,
+		"step":           step.String(),
+	})
+}
+
+// parseMetricsTime acepta una fecha RFC 3339 o una duración hacia atrás desde now
+// ("24h" son las últimas 24 horas)
+func parseMetricsTime(value string, now, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("must be an RFC 3339 date or a duration like 24h")
+}
+
+// defaultMetricsStep elige un intervalo que da entre 48 y 168 puntos para rangos
+// de hasta una semana
+func defaultMetricsStep(span time.Duration) time.Duration {
+	switch {
+	case span <= 6*time.Hour:
+		return 5 * time.Minute
+	case span <= 48*time.Hour:
+		return 15 * time.Minute
+	case span <= 7*24*time.Hour:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func (h *handler) HandleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	var payload alertRuleRequest
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		formattedErrors := FormatValidationErrors(errors)
+		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
+		return
+	}
+
+	rule, err := NewAlertRule(userID, payload)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := store.CreateAlertRule(rule)
+	if err != nil {
+		if errors.Is(err, errInvalidAlertRule) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// El secreto de firma solo se muestra al crear la regla
+	WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *handler) HandleListAlertRules(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	rules, err := store.GetAlertRules(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range rules {
+		rules[i].Secret = ""
+	}
+
+	WriteJSON(w, http.StatusOK, rules)
+}
+
+func (h *handler) HandleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	deleted, err := store.DeleteAlertRule(userID, r.PathValue("id"))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		WriteError(w, http.StatusNotFound, "alert rule not found")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]string{"deleted": r.PathValue("id")})
+}
+
+// HandleListAlertDeliveries devuelve los últimos 100 envíos de alertas, con
+// ?ruleId= y ?status= (pending, delivered o failed) opcionales
+func (h *handler) HandleListAlertDeliveries(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "failed" && status != "canceled" {
+		WriteError(w, http.StatusBadRequest, "status must be pending, delivered, failed or canceled")
+		return
+	}
+
+	deliveries, err := store.GetDeliveries(userID, "alert", r.URL.Query().Get("ruleId"), status, 100)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, deliveries)
+}
+
+func (h *handler) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	var payload webhookSubscriptionRequest
+	if err := ParseJSON(r, &payload); err != nil {
+		httpLog.WarnContext(r.Context(), "invalid JSON body", "error", err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		formattedErrors := FormatValidationErrors(errors)
+		WriteError(w, http.StatusBadRequest, "invalid payload: "+formattedErrors)
+		return
+	}
+
+	sub, err := NewWebhookSubscription(userID, payload)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := store.CreateWebhookSubscription(sub)
+	if err != nil {
+		if errors.Is(err, errInvalidWebhook) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// El secreto de firma solo se muestra al crear el webhook
+	WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *handler) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	subs, err := store.GetWebhookSubscriptions(userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	WriteJSON(w, http.StatusOK, subs)
+}
+
+func (h *handler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	deleted, err := store.DeleteWebhookSubscription(userID, r.PathValue("id"))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		WriteError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]string{"deleted": r.PathValue("id")})
+}
+
+// HandlePingWebhook encola un evento "ping"; el resultado se ve en las entregas
+func (h *handler) HandlePingWebhook(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	sub, err := store.GetWebhookSubscription(userID, r.PathValue("id"))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if sub == nil {
+		WriteError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	if err := store.PingWebhook(sub); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusAccepted, map[string]string{"queued": EventPing})
+}
+
+func (h *handler) HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "failed" && status != "canceled" {
+		WriteError(w, http.StatusBadRequest, "status must be pending, delivered, failed or canceled")
+		return
+	}
+
+	deliveries, err := store.GetDeliveries(userID, "webhook", r.URL.Query().Get("webhookId"), status, 100)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, deliveries)
+}
+
+// HandleReplayDelivery vuelve a encolar una entrega fallida, de alerta o de evento
+func (h *handler) HandleReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	store := h.store.WithContext(r.Context())
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		httpLog.WarnContext(r.Context(), "unauthorized access", "error", err)
+		WriteError(w, http.StatusUnauthorized, "unauthorized: "+err.Error())
+		return
+	}
+
+	found, err := store.ReplayDelivery(userID, r.PathValue("id"))
+	if !found && err == nil {
+		WriteError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+	if err != nil {
+		if errors.Is(err, errDeliveryNotFailed) {
+			WriteError(w, http.StatusConflict, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusAccepted, map[string]string{"replayed": r.PathValue("id")})
 }
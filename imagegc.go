@@ -0,0 +1,280 @@
+package main
+
+//Recolección de basura de imágenes: releases que exceden la retención, imágenes
+//de servicios eliminados, imágenes colgantes y caché de build sobre el límite.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+)
+
+var (
+	imageGCInterval = GetEnvDuration("IMAGE_GC_INTERVAL", time.Hour)
+	// Las imágenes más nuevas pueden ser de un build en curso que aún no guardó su release
+	imageGCMinAge = GetEnvDuration("IMAGE_GC_MIN_AGE", time.Hour)
+	// Por encima de este tamaño se poda la caché de build hasta dejarla en el límite
+	buildCacheMaxMB = int64(GetEnvInt("BUILD_CACHE_MAX_MB", 5120))
+)
+
+// CollectImages hace una pasada del GC. Con dryRun solo calcula el reporte, sin
+// borrar nada.
+func (s *store) CollectImages(dryRun bool) (*GCReport, error) {
+	s, end := s.trace("store.CollectImages")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Minute)
+	defer cancel()
+
+	report := &GCReport{DryRun: dryRun, StartedAt: time.Now()}
+
+	if err := s.collectReleases(report); err != nil {
+		return nil, err
+	}
+
+	// Imágenes usadas por algún contenedor, aunque esté detenido
+	containers, err := s.client.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	inUse := map[string]bool{}
+	for _, c := range containers {
+		inUse[c.ImageID] = true
+	}
+
+	if err := s.collectOrphanImages(ctx, report, inUse); err != nil {
+		return nil, err
+	}
+	if err := s.collectDanglingImages(ctx, report, inUse); err != nil {
+		return nil, err
+	}
+	if err := s.collectBuildCache(ctx, report); err != nil {
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// collectReleases aplica releaseRetention a todos los servicios con releases
+func (s *store) collectReleases(report *GCReport) error {
+	releases, err := s.GetAllReleases()
+	if err != nil {
+		return err
+	}
+
+	type serviceKey struct {
+		userID string
+		name   ServiceName
+	}
+	seen := map[serviceKey]bool{}
+	for _, rel := range releases {
+		key := serviceKey{rel.UserID, rel.ContainerName}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		prune, err := s.releasesToPrune(rel.UserID, rel.ContainerName)
+		if err != nil {
+			return err
+		}
+		for _, p := range prune {
+			report.Releases = append(report.Releases, p.Image)
+		}
+		if report.DryRun || len(prune) == 0 {
+			continue
+		}
+		if err := s.PruneReleases(rel.UserID, rel.ContainerName); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+	return nil
+}
+
+// collectOrphanImages elimina las imágenes "svc-…" cuyas etiquetas no pertenecen a
+// ninguna release ni a ningún servicio existente
+func (s *store) collectOrphanImages(ctx context.Context, report *GCReport, inUse map[string]bool) error {
+	records, err := s.GetAllContainers()
+	if err != nil {
+		return err
+	}
+	releases, err := s.GetAllReleases()
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, rec := range records {
+		if rec.DockerName != "" {
+			known[rec.DockerName+":latest"] = true
+		}
+	}
+	for _, rel := range releases {
+		known[rel.Image] = true
+		known[dockerServiceName(rel.UserID, rel.ContainerName)+":latest"] = true
+	}
+
+	images, err := s.client.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+
+	for _, img := range images {
+		if len(img.RepoTags) == 0 || inUse[img.ID] || !olderThanGCMinAge(img) {
+			continue
+		}
+		orphan := true
+		for _, tag := range img.RepoTags {
+			if !strings.HasPrefix(tag, "svc-") || known[tag] {
+				orphan = false
+				break
+			}
+		}
+		if !orphan {
+			continue
+		}
+
+		report.OrphanImages = append(report.OrphanImages, gcImage(img))
+		report.ReclaimableMB += img.Size >> 20
+		if !report.DryRun {
+			s.removeImage(ctx, report, img)
+		}
+	}
+	return nil
+}
+
+// collectDanglingImages elimina las capas sin etiqueta que dejan las imágenes reemplazadas
+func (s *store) collectDanglingImages(ctx context.Context, report *GCReport, inUse map[string]bool) error {
+	images, err := s.client.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("dangling", "true")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list dangling images: %w", err)
+	}
+
+	for _, img := range images {
+		if inUse[img.ID] || !olderThanGCMinAge(img) {
+			continue
+		}
+
+		report.DanglingImages = append(report.DanglingImages, gcImage(img))
+		report.ReclaimableMB += img.Size >> 20
+		if !report.DryRun {
+			s.removeImage(ctx, report, img)
+		}
+	}
+	return nil
+}
+
+// collectBuildCache poda la caché de build cuando supera buildCacheMaxMB
+func (s *store) collectBuildCache(ctx context.Context, report *GCReport) error {
+	if buildCacheMaxMB <= 0 {
+		return nil
+	}
+
+	usage, err := s.client.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+	if err != nil {
+		return fmt.Errorf("failed to read build cache usage: %w", err)
+	}
+
+	var size int64
+	for _, rec := range usage.BuildCache {
+		if !rec.Shared {
+			size += rec.Size
+		}
+	}
+
+	report.BuildCache = GCBuildCache{SizeMB: size >> 20, LimitMB: buildCacheMaxMB}
+	if size>>20 <= buildCacheMaxMB {
+		return nil
+	}
+	report.BuildCache.Prune = true
+	report.ReclaimableMB += size>>20 - buildCacheMaxMB
+	if report.DryRun {
+		return nil
+	}
+
+	opts := build.CachePruneOptions{}
+	// keep-storage se reemplazó por reserved-space en la API 1.48
+	if versions.GreaterThanOrEqualTo(s.client.ClientVersion(), "1.48") {
+		opts.ReservedSpace = buildCacheMaxMB << 20
+	} else {
+		opts.KeepStorage = buildCacheMaxMB << 20
+	}
+	pruned, err := s.client.BuildCachePrune(ctx, opts)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("build cache: %v", err))
+		return nil
+	}
+	report.BuildCache.ReclaimedMB = int64(pruned.SpaceReclaimed >> 20)
+	return nil
+}
+
+func (s *store) removeImage(ctx context.Context, report *GCReport, img image.Summary) {
+	_, err := s.client.ImageRemove(ctx, img.ID, image.RemoveOptions{Force: len(img.RepoTags) > 1, PruneChildren: true})
+	if err == nil || client.IsErrNotFound(err) {
+		return
+	}
+	// Puede haber quedado en uso entre el listado y el borrado; se reintenta en la próxima pasada
+	if !cerrdefs.IsConflict(err) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", img.ID, err))
+	}
+}
+
+func olderThanGCMinAge(img image.Summary) bool {
+	return time.Since(time.Unix(img.Created, 0)) >= imageGCMinAge
+}
+
+func gcImage(img image.Summary) GCImage {
+	return GCImage{
+		ID:     img.ID,
+		Tags:   img.RepoTags,
+		SizeMB: img.Size >> 20,
+	}
+}
+
+func StartImageGC(ctx context.Context, s *store, interval time.Duration) {
+	if interval <= 0 {
+		gcLog.Info("desactivado")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			gc, span := startSpan(s, "gc.run")
+			report, err := gc.CollectImages(false)
+			span.End()
+			if err != nil {
+				gcLog.Error("error en la limpieza", "error", err)
+				continue
+			}
+			gcLog.Info("limpieza terminada",
+				"releases", len(report.Releases),
+				"orphanImages", len(report.OrphanImages),
+				"danglingImages", len(report.DanglingImages),
+				"buildCacheMb", report.BuildCache.SizeMB,
+				"reclaimedMb", report.ReclaimableMB,
+			)
+			for _, e := range report.Errors {
+				gcLog.Warn("error en la limpieza", "error", e)
+			}
+		case <-ctx.Done():
+			gcLog.Info("bucle detenido")
+			return
+		}
+	}
+}
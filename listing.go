@@ -0,0 +1,401 @@
+package main
+
+//Paginación por cursor para los listados. El cursor es opaco para el cliente:
+//guarda el campo de orden, su valor y el _id del último elemento de la página, así
+//la página siguiente no depende de un skip y no se corre si se agregan documentos.
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errInvalidListQuery = errors.New("invalid list query")
+
+type sortKind int
+
+const (
+	sortTime sortKind = iota
+	sortString
+)
+
+// Campos por los que se puede ordenar cada listado
+var (
+	containerSorts = map[string]sortKind{"createdAt": sortTime, "updatedAt": sortTime, "containerName": sortString}
+	historySorts   = map[string]sortKind{"createdAt": sortTime}
+)
+
+// ListQuery es una página pedida: filtro, orden y desde dónde seguir
+type ListQuery struct {
+	Filter bson.M
+	Sort   string
+	Desc   bool
+	Limit  int64
+	After  *pageCursor
+	kind   sortKind
+}
+
+type pageCursor struct {
+	Sort  string             `json:"s"`
+	Desc  bool               `json:"d,omitempty"`
+	Value json.RawMessage    `json:"v"`
+	ID    primitive.ObjectID `json:"id"`
+}
+
+// Page es una página de resultados. NextCursor queda vacío en la última página y
+// Total cuenta todos los documentos del filtro, no solo los de la página.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	Total      int64
+}
+
+// ParseListQuery lee limit, sort ("campo" o "-campo" para descendente) y cursor. Por
+// defecto ordena por createdAt descendente.
+func ParseListQuery(q neturl.Values, sorts map[string]sortKind) (ListQuery, error) {
+	lq := ListQuery{Filter: bson.M{}, Sort: "createdAt", Desc: true, Limit: defaultPageSize}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxPageSize {
+			return lq, fmt.Errorf("%w: limit must be between 1 and %d", errInvalidListQuery, maxPageSize)
+		}
+		lq.Limit = n
+	}
+
+	if v := q.Get("sort"); v != "" {
+		lq.Desc = strings.HasPrefix(v, "-")
+		lq.Sort = strings.TrimPrefix(v, "-")
+	}
+	kind, ok := sorts[lq.Sort]
+	if !ok {
+		return lq, fmt.Errorf("%w: cannot sort by %q", errInvalidListQuery, lq.Sort)
+	}
+	lq.kind = kind
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return lq, err
+		}
+		// Un cursor solo vale para el orden con el que se generó
+		if c.Sort != lq.Sort || c.Desc != lq.Desc {
+			return lq, fmt.Errorf("%w: cursor does not match sort", errInvalidListQuery)
+		}
+		lq.After = c
+	}
+	return lq, nil
+}
+
+func decodeCursor(v string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	var c pageCursor
+	if err != nil || json.Unmarshal(raw, &c) != nil || c.ID.IsZero() {
+		return nil, fmt.Errorf("%w: malformed cursor", errInvalidListQuery)
+	}
+	return &c, nil
+}
+
+// setTimeRange agrega from y to (RFC 3339) como rango de createdAt
+func setTimeRange(filter bson.M, q neturl.Values) error {
+	rng := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("%w: %s must be an RFC 3339 time", errInvalidListQuery, param)
+		}
+		rng[op] = t
+	}
+	if len(rng) > 0 {
+		filter["createdAt"] = rng
+	}
+	return nil
+}
+
+// nameFilter busca por prefijo del nombre, que usa el índice userId+containerName
+func nameFilter(name string) bson.M {
+	return bson.M{"$regex": "^" + regexp.QuoteMeta(name)}
+}
+
+// ContainerListFilter arma el filtro de /containers/list: name (prefijo), type,
+// status (running o stopped), from y to
+func ContainerListFilter(userID string, q neturl.Values) (bson.M, error) {
+	filter := bson.M{"userId": userID}
+	if v := q.Get("name"); v != "" {
+		filter["containerName"] = nameFilter(v)
+	}
+	if v := q.Get("type"); v != "" {
+		filter["type"] = v
+	}
+	switch q.Get("status") {
+	case "":
+	case "running":
+		filter["status"] = true
+	case "stopped":
+		filter["status"] = false
+	default:
+		return nil, fmt.Errorf("%w: status must be running or stopped", errInvalidListQuery)
+	}
+	if err := setTimeRange(filter, q); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// HistoryListFilter arma el filtro de /containers/history: name (prefijo), event
+// (uno o varios separados por coma), status (failed u ok), from y to
+func HistoryListFilter(userID string, q neturl.Values) (bson.M, error) {
+	filter := bson.M{"userId": userID}
+	if v := q.Get("name"); v != "" {
+		filter["containerName"] = nameFilter(v)
+	}
+
+	events := historyEvents
+	if v := q.Get("event"); v != "" {
+		events = nil
+		for _, e := range strings.Split(v, ",") {
+			e = strings.TrimSpace(e)
+			if !slices.Contains(historyEvents, e) {
+				return nil, fmt.Errorf("%w: unknown event %q", errInvalidListQuery, e)
+			}
+			events = append(events, e)
+		}
+	}
+
+	status := q.Get("status")
+	switch status {
+	case "", "failed", "ok":
+	default:
+		return nil, fmt.Errorf("%w: status must be failed or ok", errInvalidListQuery)
+	}
+	if status != "" {
+		events = slices.DeleteFunc(slices.Clone(events), func(e string) bool {
+			return historyFailures[e] != (status == "failed")
+		})
+	}
+	if q.Get("event") != "" || status != "" {
+		filter["event"] = bson.M{"$in": events}
+	}
+
+	if err := setTimeRange(filter, q); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// mongoFilter agrega al filtro la condición "después del cursor"
+func (q ListQuery) mongoFilter() (bson.M, error) {
+	if q.After == nil {
+		return q.Filter, nil
+	}
+
+	var value any
+	switch q.kind {
+	case sortTime:
+		var t time.Time
+		if err := json.Unmarshal(q.After.Value, &t); err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", errInvalidListQuery)
+		}
+		value = t
+	default:
+		var s string
+		if err := json.Unmarshal(q.After.Value, &s); err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", errInvalidListQuery)
+		}
+		value = s
+	}
+
+	op := "$gt"
+	if q.Desc {
+		op = "$lt"
+	}
+	after := bson.M{"$or": bson.A{
+		bson.M{q.Sort: bson.M{op: value}},
+		bson.M{q.Sort: value, "_id": bson.M{op: q.After.ID}},
+	}}
+	return bson.M{"$and": bson.A{q.Filter, after}}, nil
+}
+
+// nextCursor arma el cursor a partir del último documento de la página
+func (q ListQuery) nextCursor(last bson.Raw) (string, error) {
+	id, ok := last.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return "", fmt.Errorf("document without ObjectID")
+	}
+
+	rv := last.Lookup(q.Sort)
+	var value any
+	switch q.kind {
+	case sortTime:
+		t, ok := rv.TimeOK()
+		if !ok {
+			return "", fmt.Errorf("document without %s", q.Sort)
+		}
+		value = t.UTC()
+	default:
+		value = rv.StringValue()
+	}
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(pageCursor{Sort: q.Sort, Desc: q.Desc, Value: v, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// findPage trae una página de la colección. Pide un documento de más para saber si
+// hay una página siguiente.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, q ListQuery) (Page[T], error) {
+	page := Page[T]{Items: []T{}}
+
+	filter, err := q.mongoFilter()
+	if err != nil {
+		return page, err
+	}
+
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: q.Sort, Value: dir}, {Key: "_id", Value: dir}}).
+		SetLimit(q.Limit + 1)
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return page, fmt.Errorf("failed to query %s: %w", collection.Name(), err)
+	}
+	defer cur.Close(ctx)
+
+	var last bson.Raw
+	for cur.Next(ctx) {
+		if int64(len(page.Items)) == q.Limit {
+			next, err := q.nextCursor(last)
+			if err != nil {
+				return page, fmt.Errorf("failed to build cursor: %w", err)
+			}
+			page.NextCursor = next
+			break
+		}
+		var item T
+		if err := cur.Decode(&item); err != nil {
+			return page, fmt.Errorf("failed to decode %s: %w", collection.Name(), err)
+		}
+		page.Items = append(page.Items, item)
+		last = slices.Clone(cur.Current)
+	}
+	if err := cur.Err(); err != nil {
+		return page, fmt.Errorf("cursor error: %w", err)
+	}
+
+	page.Total, err = collection.CountDocuments(ctx, q.Filter)
+	if err != nil {
+		return page, fmt.Errorf("failed to count %s: %w", collection.Name(), err)
+	}
+	return page, nil
+}
+
+func (s *store) ListContainerRecords(q ListQuery) (Page[ContainerRecord], error) {
+	s, end := s.trace("store.ListContainerRecords")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+	return findPage[ContainerRecord](ctx, s.database.Collection("containers"), q)
+}
+
+// EachRunningContainer recorre por páginas de maxPageSize los contenedores en
+// ejecución del usuario, sin cargarlos todos en memoria; se detiene en el primer
+// error de fn
+func (s *store) EachRunningContainer(userID string, fn func(ContainerRecord) error) error {
+	filter := bson.M{"userId": userID, "status": true}
+	q := ListQuery{Filter: filter, Sort: "containerName", Limit: maxPageSize, kind: sortString}
+	for {
+		page, err := s.ListContainerRecords(q)
+		if err != nil {
+			return err
+		}
+		for _, rec := range page.Items {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		if q.After, err = decodeCursor(page.NextCursor); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *store) ListHistoryEntries(q ListQuery) (Page[ContainerUpdate], error) {
+	s, end := s.trace("store.ListHistoryEntries")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+	return findPage[ContainerUpdate](ctx, s.database.Collection("history"), q)
+}
+
+// EnsureListIndexes crea los índices de los listados y de las búsquedas por
+// usuario. Si ya hay duplicados, el índice único falla y se informa el error.
+func (s *store) EnsureListIndexes() error {
+	s, end := s.trace("store.EnsureListIndexes")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), time.Minute)
+	defer cancel()
+
+	indexes := map[string][]mongo.IndexModel{
+		"containers": {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "containerName", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "dockerName", Value: 1}}},
+		},
+		"history": {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "containerName", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "event", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		"configs": {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "containerName", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"tenants": {
+			{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "handle", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+
+	var errs []error
+	for name, models := range indexes {
+		if _, err := s.database.Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create %s indexes: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
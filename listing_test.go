@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	neturl "net/url"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testCursor arma el cursor que devolvería una página que termina en doc
+func testCursor(t *testing.T, q ListQuery, doc bson.M) string {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := q.nextCursor(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParseListQuery(t *testing.T) {
+	id := primitive.NewObjectID()
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	descCursor := testCursor(t, ListQuery{Sort: "createdAt", Desc: true, kind: sortTime}, bson.M{"_id": id, "createdAt": at})
+	nameCursor := testCursor(t, ListQuery{Sort: "containerName", kind: sortString}, bson.M{"_id": id, "containerName": "api"})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantSort  string
+		wantDesc  bool
+		wantLimit int64
+		wantAfter bool
+		wantErr   bool
+	}{
+		{name: "defaults", query: "", wantSort: "createdAt", wantDesc: true, wantLimit: defaultPageSize},
+		{name: "limit", query: "limit=10", wantSort: "createdAt", wantDesc: true, wantLimit: 10},
+		{name: "max limit", query: "limit=200", wantSort: "createdAt", wantDesc: true, wantLimit: maxPageSize},
+		{name: "limit too big", query: "limit=201", wantErr: true},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "limit not a number", query: "limit=ten", wantErr: true},
+		{name: "ascending", query: "sort=containerName", wantSort: "containerName", wantLimit: defaultPageSize},
+		{name: "descending", query: "sort=-updatedAt", wantSort: "updatedAt", wantDesc: true, wantLimit: defaultPageSize},
+		{name: "unknown sort", query: "sort=memory", wantErr: true},
+		{name: "sort by internal field", query: "sort=-_id", wantErr: true},
+		{name: "cursor", query: "cursor=" + descCursor, wantSort: "createdAt", wantDesc: true, wantLimit: defaultPageSize, wantAfter: true},
+		{name: "cursor with its sort", query: "sort=containerName&cursor=" + nameCursor, wantSort: "containerName", wantLimit: defaultPageSize, wantAfter: true},
+		{name: "cursor from another sort", query: "sort=containerName&cursor=" + descCursor, wantErr: true},
+		{name: "cursor from another direction", query: "sort=createdAt&cursor=" + descCursor, wantErr: true},
+		{name: "malformed cursor", query: "cursor=abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := neturl.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lq, err := ParseListQuery(q, containerSorts)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidListQuery) {
+					t.Fatalf("ParseListQuery(%q) = %v, want errInvalidListQuery", tt.query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lq.Sort != tt.wantSort || lq.Desc != tt.wantDesc || lq.Limit != tt.wantLimit || (lq.After != nil) != tt.wantAfter {
+				t.Fatalf("ParseListQuery(%q) = %+v", tt.query, lq)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+		valid  bool
+	}{
+		{"valid", encode(`{"s":"createdAt","d":true,"v":"2026-03-01T12:30:00Z","id":"` + id.Hex() + `"}`), true},
+		{"not base64", "%%%", false},
+		{"not json", encode("hola"), false},
+		{"missing id", encode(`{"s":"createdAt","v":"2026-03-01T12:30:00Z"}`), false},
+		{"invalid id", encode(`{"s":"createdAt","v":"x","id":"zzz"}`), false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := decodeCursor(tt.cursor)
+			if (err == nil) != tt.valid {
+				t.Fatalf("decodeCursor() = %v, valid = %v", err, tt.valid)
+			}
+			if err != nil && !errors.Is(err, errInvalidListQuery) {
+				t.Fatalf("decodeCursor() = %v, want errInvalidListQuery", err)
+			}
+			if tt.valid && c.ID != id {
+				t.Fatalf("decodeCursor() id = %s, want %s", c.ID.Hex(), id.Hex())
+			}
+		})
+	}
+}
+
+func TestMongoFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	base := bson.M{"userId": "u1"}
+
+	tests := []struct {
+		name string
+		q    ListQuery
+		doc  bson.M
+		want bson.M
+	}{
+		{
+			name: "first page",
+			q:    ListQuery{Filter: base, Sort: "createdAt", Desc: true, kind: sortTime},
+			want: base,
+		},
+		{
+			name: "descending time",
+			q:    ListQuery{Filter: base, Sort: "createdAt", Desc: true, kind: sortTime},
+			doc:  bson.M{"_id": id, "createdAt": at},
+			want: bson.M{"$and": bson.A{base, bson.M{"$or": bson.A{
+				bson.M{"createdAt": bson.M{"$lt": at}},
+				bson.M{"createdAt": at, "_id": bson.M{"$lt": id}},
+			}}}},
+		},
+		{
+			name: "ascending name",
+			q:    ListQuery{Filter: base, Sort: "containerName", kind: sortString},
+			doc:  bson.M{"_id": id, "containerName": "api"},
+			want: bson.M{"$and": bson.A{base, bson.M{"$or": bson.A{
+				bson.M{"containerName": bson.M{"$gt": "api"}},
+				bson.M{"containerName": "api", "_id": bson.M{"$gt": id}},
+			}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.q
+			if tt.doc != nil {
+				c, err := decodeCursor(testCursor(t, q, tt.doc))
+				if err != nil {
+					t.Fatal(err)
+				}
+				q.After = c
+			}
+			got, err := q.mongoFilter()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mongoFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Un valor que no corresponde al tipo del campo de orden se rechaza
+	bad := ListQuery{Filter: base, Sort: "createdAt", kind: sortTime, After: &pageCursor{Sort: "createdAt", Value: []byte(`42`), ID: id}}
+	if _, err := bad.mongoFilter(); !errors.Is(err, errInvalidListQuery) {
+		t.Fatalf("mongoFilter() with a bad value = %v, want errInvalidListQuery", err)
+	}
+}
+
+func TestHistoryListFilter(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	failures := slices.DeleteFunc(slices.Clone(historyEvents), func(e string) bool { return !historyFailures[e] })
+	successes := slices.DeleteFunc(slices.Clone(historyEvents), func(e string) bool { return historyFailures[e] })
+
+	tests := []struct {
+		name    string
+		query   string
+		want    bson.M
+		wantErr bool
+	}{
+		{
+			name:  "only user",
+			query: "",
+			want:  bson.M{"userId": "u1"},
+		},
+		{
+			name:  "name prefix is escaped",
+			query: "name=api.v1",
+			want:  bson.M{"userId": "u1", "containerName": bson.M{"$regex": `^api\.v1`}},
+		},
+		{
+			name:  "events",
+			query: "event=" + HistoryCreated + "," + HistoryCrashed,
+			want:  bson.M{"userId": "u1", "event": bson.M{"$in": []string{HistoryCreated, HistoryCrashed}}},
+		},
+		{
+			name:  "failed",
+			query: "status=failed",
+			want:  bson.M{"userId": "u1", "event": bson.M{"$in": failures}},
+		},
+		{
+			name:  "ok",
+			query: "status=ok",
+			want:  bson.M{"userId": "u1", "event": bson.M{"$in": successes}},
+		},
+		{
+			name:  "events and status",
+			query: "event=" + HistoryCreated + "," + HistoryCrashed + "&status=failed",
+			want:  bson.M{"userId": "u1", "event": bson.M{"$in": []string{HistoryCrashed}}},
+		},
+		{
+			name:  "no event matches",
+			query: "event=" + HistoryCreated + "&status=failed",
+			want:  bson.M{"userId": "u1", "event": bson.M{"$in": []string{}}},
+		},
+		{
+			name:  "time range",
+			query: "from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339),
+			want:  bson.M{"userId": "u1", "createdAt": bson.M{"$gte": from, "$lt": to}},
+		},
+		{name: "unknown event", query: "event=exploded", wantErr: true},
+		{name: "unknown status", query: "status=bad", wantErr: true},
+		{name: "bad time", query: "from=yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := neturl.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := HistoryListFilter("u1", q)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidListQuery) {
+					t.Fatalf("HistoryListFilter(%q) = %v, want errInvalidListQuery", tt.query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("HistoryListFilter(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
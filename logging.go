@@ -0,0 +1,227 @@
+package main
+
+//Logs estructurados en JSON con log/slog. Cada componente tiene su propio logger
+//con un nivel configurable, cada línea lleva el ID de la petición (y de la traza)
+//que la originó y los campos sensibles salen tapados.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// Nivel por defecto: debug, info, warn o error
+	logLevel = GetEnv("LOG_LEVEL", "info")
+	// Niveles por componente, por ejemplo "health=warn,docker=debug"
+	logLevels = GetEnvList("LOG_LEVELS", nil)
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// Un X-Request-ID entrante solo se reutiliza si es corto y no trae caracteres raros
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Claves cuyos valores nunca se escriben en los logs
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+const redacted = "[REDACTED]"
+
+var (
+	rootLogHandler  slog.Handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactAttr})
+	componentLevels              = parseLogLevels(logLevel, logLevels)
+
+	httpLog    = newLogger("http")
+	authLog    = newLogger("auth")
+	dockerLog  = newLogger("docker")
+	buildLog   = newLogger("build")
+	deployLog  = newLogger("deploy")
+	healthLog  = newLogger("health")
+	reconLog   = newLogger("reconciler")
+	gcLog      = newLogger("gc")
+	metricsLog = newLogger("metrics")
+	migrateLog = newLogger("migrate")
+	networkLog = newLogger("network")
+	alertLog   = newLogger("alerts")
+	webhookLog = newLogger("webhooks")
+	historyLog = newLogger("history")
+	tenantLog  = newLogger("tenant")
+)
+
+func init() {
+	// log.Printf (y lo que todavía lo use) también sale en JSON
+	slog.SetDefault(slog.New(componentHandler{next: rootLogHandler, level: componentLevels[""]}))
+	log.SetFlags(0)
+	flushEnvWarnings()
+}
+
+// parseLogLevels arma el nivel de cada componente; la clave "" es el nivel por defecto
+func parseLogLevels(def string, perComponent []string) map[string]slog.Level {
+	var base slog.Level
+	if err := base.UnmarshalText([]byte(def)); err != nil {
+		log.Printf("invalid value for LOG_LEVEL: %q, using info", def)
+		base = slog.LevelInfo
+	}
+	levels := map[string]slog.Level{"": base}
+	for _, item := range perComponent {
+		name, value, ok := strings.Cut(item, "=")
+		var l slog.Level
+		if !ok || l.UnmarshalText([]byte(value)) != nil {
+			log.Printf("invalid entry in LOG_LEVELS: %q", item)
+			continue
+		}
+		levels[strings.TrimSpace(name)] = l
+	}
+	return levels
+}
+
+// newLogger devuelve el logger de un componente, con su nivel de LOG_LEVELS o el
+// de LOG_LEVEL
+func newLogger(component string) *slog.Logger {
+	level, ok := componentLevels[component]
+	if !ok {
+		level = componentLevels[""]
+	}
+	return slog.New(componentHandler{next: rootLogHandler, level: level}).With("component", component)
+}
+
+// componentHandler filtra por el nivel del componente y agrega el ID de la
+// petición y de la traza que vengan en el contexto
+type componentHandler struct {
+	next  slog.Handler
+	level slog.Level
+}
+
+func (h componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("traceId", sc.TraceID().String()))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return componentHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{next: h.next.WithGroup(name), level: h.level}
+}
+
+// redactAttr tapa el valor de cualquier atributo cuya clave parezca sensible,
+// también dentro de grupos
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+// RequestIDFromContext devuelve el ID de la petición en curso, o "" si no hay
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID reutiliza el X-Request-ID del cliente (o de Traefik) o genera
+// uno, lo devuelve en la respuesta y lo deja en el contexto para los logs. Al
+// terminar escribe una línea por petición.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case r.URL.Path == "/metrics":
+			// Los scrapes llegan cada pocos segundos
+			level = slog.LevelDebug
+		}
+		httpLog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", r.Pattern,
+			"status", rec.status,
+			"durationMs", time.Since(started).Milliseconds(),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// logWriter escribe cada línea completa como un log de nivel debug; sirve para la
+// salida de docker build y docker pull. Close envía lo que haya quedado sin salto
+// de línea al final.
+type logWriter struct {
+	ctx    context.Context
+	logger *slog.Logger
+	msg    string
+	buf    []byte
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.log(string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+func (l *logWriter) Close() error {
+	if len(l.buf) > 0 {
+		l.log(string(l.buf))
+		l.buf = nil
+	}
+	return nil
+}
+
+func (l *logWriter) log(line string) {
+	if line = strings.TrimSpace(line); line != "" {
+		l.logger.DebugContext(l.ctx, l.msg, "line", line)
+	}
+}
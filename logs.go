@@ -0,0 +1,204 @@
+package main
+
+//Logs de los contenedores de usuario. Docker multiplexa stdout y stderr en un solo
+//flujo con cabeceras de 8 bytes; el handler los separa con stdcopy antes de
+//enviarlos al cliente.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	defaultLogTail = "100"
+	// Comentario SSE periódico para que los proxies no corten un follow sin actividad
+	logKeepAlive = 15 * time.Second
+)
+
+// parseLogOptions arma las opciones de docker logs desde la query: tail (número o
+// "all"), since y until (RFC 3339, timestamp Unix o duración como "10m"),
+// timestamps y follow
+func parseLogOptions(r *http.Request) (container.LogsOptions, error) {
+	q := r.URL.Query()
+	opts := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       defaultLogTail,
+	}
+
+	if tail := q.Get("tail"); tail != "" {
+		if n, err := strconv.Atoi(tail); tail != "all" && (err != nil || n < 0) {
+			return opts, fmt.Errorf("tail must be a non-negative number or \"all\"")
+		}
+		opts.Tail = tail
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *string
+	}{{"since", &opts.Since}, {"until", &opts.Until}} {
+		value := q.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if !validLogTime(value) {
+			return opts, fmt.Errorf("%s must be an RFC 3339 date, a Unix timestamp or a duration", param.name)
+		}
+		*param.dst = value
+	}
+
+	var err error
+	if opts.Timestamps, err = parseBoolParam("timestamps", q.Get("timestamps")); err != nil {
+		return opts, err
+	}
+	if opts.Follow, err = parseBoolParam("follow", q.Get("follow")); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+func validLogTime(value string) bool {
+	if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return true
+	}
+	d, err := time.ParseDuration(value)
+	return err == nil && d >= 0
+}
+
+func parseBoolParam(name, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+// ContainerLogs abre el flujo multiplexado de logs del contenedor; con Follow no
+// termina hasta que se cancela ctx o el contenedor se detiene
+func (s *store) ContainerLogs(ctx context.Context, dockerName string, opts container.LogsOptions) (io.ReadCloser, error) {
+	rc, err := s.client.ContainerLogs(ctx, dockerName, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs of %s: %w", dockerName, err)
+	}
+	return rc, nil
+}
+
+// sseStream escribe eventos Server-Sent Events; es seguro usarlo desde varias goroutines
+type sseStream struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newSSEStream(w http.ResponseWriter) *sseStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	return &sseStream{w: w, rc: http.NewResponseController(w)}
+}
+
+func (s *sseStream) Event(event, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	if _, err := io.WriteString(s.w, b.String()); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *sseStream) Comment(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := io.WriteString(s.w, ": "+text+"\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// KeepAlive manda un comentario cada interval hasta que se cancela ctx
+func (s *sseStream) KeepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("ping"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sseLineWriter manda cada línea completa como un evento; Close envía lo que
+// haya quedado sin salto de línea al final
+type sseLineWriter struct {
+	stream *sseStream
+	event  string
+	buf    []byte
+}
+
+func (l *sseLineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSuffix(string(l.buf[:i]), "\r")
+		l.buf = l.buf[i+1:]
+		if err := l.stream.Event(l.event, line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (l *sseLineWriter) Close() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	line := string(l.buf)
+	l.buf = nil
+	return l.stream.Event(l.event, line)
+}
+
+// flushWriter envía cada escritura al cliente de inmediato (transferencia chunked)
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
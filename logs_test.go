@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseLogOptions(t *testing.T) {
+	tests := []struct {
+		query      string
+		tail       string
+		since      string
+		until      string
+		timestamps bool
+		follow     bool
+		wantErr    bool
+	}{
+		{query: "", tail: defaultLogTail},
+		{query: "tail=20", tail: "20"},
+		{query: "tail=0", tail: "0"},
+		{query: "tail=all", tail: "all"},
+		{query: "tail=-1", wantErr: true},
+		{query: "tail=many", wantErr: true},
+		{query: "since=10m", tail: defaultLogTail, since: "10m"},
+		{query: "since=2026-03-01T12:00:00Z&until=2026-03-01T13:00:00.5Z", tail: defaultLogTail, since: "2026-03-01T12:00:00Z", until: "2026-03-01T13:00:00.5Z"},
+		{query: "since=1700000000.5", tail: defaultLogTail, since: "1700000000.5"},
+		{query: "since=-5m", wantErr: true},
+		{query: "until=yesterday", wantErr: true},
+		{query: "timestamps=true&follow=1", tail: defaultLogTail, timestamps: true, follow: true},
+		{query: "follow=false", tail: defaultLogTail},
+		{query: "follow=yes", wantErr: true},
+		{query: "timestamps=on", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/containers/api/logs?"+tt.query, nil)
+			opts, err := parseLogOptions(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseLogOptions(%q) = %+v, want error", tt.query, opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !opts.ShowStdout || !opts.ShowStderr || opts.Tail != tt.tail || opts.Since != tt.since ||
+				opts.Until != tt.until || opts.Timestamps != tt.timestamps || opts.Follow != tt.follow {
+				t.Fatalf("parseLogOptions(%q) = %+v", tt.query, opts)
+			}
+		})
+	}
+}
+
+func TestSSELineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "one line",
+			writes: []string{"hola\n"},
+			want:   "event: stdout\ndata: hola\n\n",
+		},
+		{
+			name:   "line split across writes",
+			writes: []string{"ho", "la\nchau", "\n"},
+			want:   "event: stdout\ndata: hola\n\nevent: stdout\ndata: chau\n\n",
+		},
+		{
+			name:   "crlf",
+			writes: []string{"hola\r\n"},
+			want:   "event: stdout\ndata: hola\n\n",
+		},
+		{
+			name:   "empty line",
+			writes: []string{"\n"},
+			want:   "event: stdout\ndata: \n\n",
+		},
+		{
+			name:   "last line without newline is sent on close",
+			writes: []string{"uno\ndos"},
+			want:   "event: stdout\ndata: uno\n\nevent: stdout\ndata: dos\n\n",
+		},
+		{
+			name:   "nothing written",
+			writes: nil,
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			stream := newSSEStream(rec)
+			w := &sseLineWriter{stream: stream, event: "stdout"}
+
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := rec.Body.String(); got != tt.want {
+				t.Fatalf("body = %q, want %q", got, tt.want)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,20 +24,29 @@ const url = "https://roble-api.openlab.uninorte.edu.co"
 const proyectID = "actividad_plataforma_de_microservicios_c12ce95f80"
 
 func main() {
-	fmt.Println("MONGO_ADDR:", mongoAddr)
-	fmt.Println("MONGO_DATABASE_NAME:", mongoDatabaseName)
+	// MONGO_ADDR puede traer la contraseña en la URL
+	slog.Info("starting", "mongoDatabase", mongoDatabaseName, "logLevel", logLevel, "logLevels", logLevels)
+
+	shutdownTracing, err := SetupTracing()
+	if err != nil {
+		slog.Error("error configuring tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
 
 	mux := http.NewServeMux()
 
 	mongoClient, err := NewMongoDBStorage(mongoAddr, mongoDatabaseName)
 	if err != nil {
-		log.Fatal("Error connecting to MongoDB: ", err)
+		slog.Error("error connecting to MongoDB", "error", err)
+		os.Exit(1)
 	}
 
 	// Docker
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation(), instrumentDocker())
 	if err != nil {
-		log.Fatalf("could not create Docker client: %v", err)
+		slog.Error("could not create Docker client", "error", err)
+		os.Exit(1)
 	}
 
 	ctx := context.Background()
@@ -45,35 +56,60 @@ func main() {
 			dockerPath := `C:\Program Files\Docker\Docker\Docker Desktop.exe`
 			cmd := exec.Command(dockerPath)
 			if err := cmd.Start(); err != nil {
-				fmt.Println("Error starting Docker Desktop:", err)
+				dockerLog.Error("error starting Docker Desktop", "error", err)
 				return
 			}
 		}()
 	}
 
 	if err := WaitForDocker(dockerClient, 10*time.Second); err != nil {
-		fmt.Println("Docker does not seem to be running.")
-		fmt.Println("Make sure Docker Desktop (or dockerd) is started and run this program again.")
+		dockerLog.Error("Docker does not seem to be running; make sure Docker Desktop (or dockerd) is started and run this program again")
 		return
 	}
 
-	fmt.Println("✅ Docker daemon is running!")
+	dockerLog.Info("Docker daemon is running")
 
 	store := NewStore(mongoClient.GetDatabase().Client(), dockerClient)
 	handler := NewHandler(store)
 	handler.registerRoutes(mux)
+	RegisterStoreMetrics(store)
 
 	// ✅ Apply CORS middleware
-	corsMux := enableCORS(mux)
+	corsMux := enableCORS(traceHTTP(withRequestID(instrumentHTTP(mux))))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	CleanWorkspaces()
+	store.CheckVolumeDriver()
+
+	// Los nombres se migran antes de arrancar cualquier contenedor
+	MigrateServiceNames(store)
+	go MigrateHistoryEvents(store)
+	go MigrateServiceContainers(store)
 	go StartPeriodically(ctx, store, 60*time.Second)
+	go StartHealthMonitor(ctx, store, healthCheckInterval)
+	go StartImageGC(ctx, store, imageGCInterval)
+	if err := store.EnsureMetricsCollection(); err != nil {
+		metricsLog.Error("error preparando la colección", "error", err)
+	}
+	go StartMetricsSampler(ctx, store, metricsSampleInterval)
+	if err := store.EnsureListIndexes(); err != nil {
+		migrateLog.Error("error creando índices", "error", err)
+	}
+	if err := store.EnsureQuotaIndexes(); err != nil {
+		migrateLog.Error("error creando índices", "error", err)
+	}
+	if err := store.EnsureWebhookIndexes(); err != nil {
+		webhookLog.Error("error creando índices", "error", err)
+	}
+	go StartWebhookWorker(ctx, store, webhookPollInterval)
+	go StartCrashWatcher(ctx, store)
 
-	log.Printf("Starting HTTP server at %s", httpAddr)
+	httpLog.Info("starting HTTP server", "addr", httpAddr)
 	if err := http.ListenAndServe(httpAddr, corsMux); err != nil {
-		log.Fatal("Failed to start http server:", err)
+		httpLog.Error("failed to start http server", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -84,13 +120,86 @@ func GetEnv(key string, fallback string) string {
 	return fallback
 }
 
+func GetEnvInt(key string, fallback int) int {
+	if value, ok := syscall.Getenv(key); ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		warnInvalidEnv(key, value, fallback)
+	}
+	return fallback
+}
+
+func GetEnvFloat(key string, fallback float64) float64 {
+	if value, ok := syscall.Getenv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		warnInvalidEnv(key, value, fallback)
+	}
+	return fallback
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := syscall.Getenv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		warnInvalidEnv(key, value, fallback.String())
+	}
+	return fallback
+}
+
+// Los GetEnv* corren al inicializar las variables del paquete, antes de que el
+// init de logging.go instale el handler JSON; hasta entonces los avisos se guardan
+var (
+	envWarnings []func()
+	envLogReady bool
+)
+
+func warnInvalidEnv(key, value string, fallback any) {
+	warn := func() {
+		slog.Warn("invalid environment value, using fallback", "key", key, "value", value, "fallback", fallback)
+	}
+	if envLogReady {
+		warn()
+		return
+	}
+	envWarnings = append(envWarnings, warn)
+}
+
+// flushEnvWarnings escribe los avisos guardados; la llama init al configurar el log
+func flushEnvWarnings() {
+	envLogReady = true
+	for _, warn := range envWarnings {
+		warn()
+	}
+	envWarnings = nil
+}
+
+// GetEnvList lee una lista separada por comas
+func GetEnvList(key string, fallback []string) []string {
+	value, ok := syscall.Getenv(key)
+	if !ok {
+		return fallback
+	}
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 // ✅ CORS Middleware
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Adjust the origin as needed
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Handle preflight OPTIONS request
@@ -104,9 +213,13 @@ func enableCORS(next http.Handler) http.Handler {
 }
 
 func StartContainersWithDB(ctx context.Context, s *store) {
+	s, span := startSpan(s, "reconciler.run")
+	defer span.End()
+	reconcilerRuns.Inc()
+
 	records, err := s.GetAllContainers()
 	if err != nil {
-		log.Printf("[startup] error leyendo containers :%v", err)
+		reconLog.ErrorContext(s.baseContext(), "error leyendo containers", "error", err)
 		return
 	}
 
@@ -119,23 +232,32 @@ func StartContainersWithDB(ctx context.Context, s *store) {
 			continue
 		}
 
-		running, err := s.IsContainerRunning(rec.ContainerName)
+		running, err := s.IsContainerRunning(rec.DockerName)
 		if err != nil {
-			log.Printf("[startup] error consultando %s: %v", rec.ContainerName, err)
+			reconLog.ErrorContext(s.baseContext(), "error consultando contenedor", "containerName", rec.ContainerName, "error", err)
 			continue
 		}
 		if running {
 			skipped++
 			continue
 		}
-		if err := s.StartContainer(rec.ContainerName); err != nil {
-			log.Printf("[startup] no se pudo iniciar %s: %v", rec.ContainerName, err)
+		if err := s.StartContainer(rec.DockerName); err != nil {
+			reconLog.ErrorContext(s.baseContext(), "no se pudo iniciar el contenedor", "containerName", rec.ContainerName, "error", err)
 			continue
 		}
 		started++
-		log.Printf("[startup] iniciado %s", rec.ContainerName)
+		reconcilerRepairs.Inc()
+		s.recordHistory(ContainerUpdate{
+			UserID:        rec.UserID,
+			ContainerName: rec.ContainerName,
+			Event:         HistoryReconciled,
+			Actor:         actorReconciler,
+			Reason:        "container was not running",
+			Release:       rec.Release,
+		})
+		reconLog.InfoContext(s.baseContext(), "contenedor iniciado", "containerName", rec.ContainerName)
 	}
-	log.Printf("[startup] verificación inicial terminada. iniciados=%d, omitidos=%d, total=%d", started, skipped, len(records))
+	reconLog.DebugContext(s.baseContext(), "verificación terminada", "started", started, "skipped", skipped, "total", len(records))
 }
 
 func StartPeriodically(ctx context.Context, s *store, interval time.Duration) {
@@ -147,7 +269,7 @@ func StartPeriodically(ctx context.Context, s *store, interval time.Duration) {
 		case <-ticker.C:
 			StartContainersWithDB(ctx, s)
 		case <-ctx.Done():
-			log.Println("[startup] bucle detenido")
+			reconLog.Info("bucle detenido")
 			return
 		}
 	}
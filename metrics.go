@@ -0,0 +1,214 @@
+package main
+
+//Historial de consumo por servicio. Un muestreador guarda periódicamente CPU,
+//memoria y red de cada contenedor en una colección time-series de MongoDB, que
+//elimina sola las muestras más viejas que la retención.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	metricsSampleInterval = GetEnvDuration("METRICS_SAMPLE_INTERVAL", time.Minute)
+	metricsRetention      = GetEnvDuration("METRICS_RETENTION", 7*24*time.Hour)
+)
+
+const (
+	metricsCollection = "metrics"
+	// Muestras tomadas en paralelo; cada una tarda alrededor de un segundo
+	metricsWorkers = 4
+	// Puntos máximos de una serie, para que un step muy chico no devuelva meses minuto a minuto
+	maxMetricsPoints = 1000
+)
+
+// EnsureMetricsCollection crea la colección time-series, o actualiza su retención
+// si ya existe
+func (s *store) EnsureMetricsCollection() error {
+	s, end := s.trace("store.EnsureMetricsCollection")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+
+	names, err := s.database.ListCollectionNames(ctx, bson.M{"name": metricsCollection})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+
+	expire := int64(metricsRetention / time.Second)
+	if len(names) > 0 {
+		cmd := bson.D{{Key: "collMod", Value: metricsCollection}, {Key: "expireAfterSeconds", Value: expire}}
+		if err := s.database.RunCommand(ctx, cmd).Err(); err != nil {
+			return fmt.Errorf("failed to update metrics retention: %w", err)
+		}
+		return nil
+	}
+
+	opts := options.CreateCollection().
+		SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField("ts").
+			SetMetaField("meta").
+			SetGranularity("minutes")).
+		SetExpireAfterSeconds(expire)
+	if err := s.database.CreateCollection(ctx, metricsCollection, opts); err != nil {
+		return fmt.Errorf("failed to create metrics collection: %w", err)
+	}
+	return nil
+}
+
+// SampleMetrics toma una muestra de cada contenedor en ejecución y las guarda juntas
+func (s *store) SampleMetrics(ctx context.Context) error {
+	records, err := s.GetAllContainers()
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		samples []any
+		memory  = map[*ContainerRecord]float64{} // % de memoria, para las alertas
+		wg      sync.WaitGroup
+		jobs    = make(chan *ContainerRecord)
+	)
+	for i := 0; i < metricsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rec := range jobs {
+				sampleCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				err := s.ContainerStats(sampleCtx, rec.DockerName, false, func(stats ResourceStats) error {
+					mu.Lock()
+					defer mu.Unlock()
+					memory[rec] = stats.MemoryPercent
+					samples = append(samples, MetricSample{
+						Time:        stats.ReadAt,
+						Meta:        MetricMeta{UserID: rec.UserID, ContainerName: rec.ContainerName},
+						CPUPercent:  stats.CPUPercent,
+						MemoryBytes: int64(stats.MemoryUsage),
+						NetworkRx:   int64(stats.NetworkRx),
+						NetworkTx:   int64(stats.NetworkTx),
+					})
+					return nil
+				})
+				cancel()
+				if err != nil {
+					metricsLog.WarnContext(ctx, "error leyendo stats", "dockerName", rec.DockerName, "error", err)
+				}
+			}
+		}()
+	}
+
+	for i := range records {
+		if records[i].Status && records[i].DockerName != "" {
+			jobs <- &records[i]
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	s.checkMemoryAlerts(memory)
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	insertCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := s.database.Collection(metricsCollection).InsertMany(insertCtx, samples); err != nil {
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	return nil
+}
+
+// metricBucket es un intervalo de step con los valores agregados de sus muestras
+type metricBucket struct {
+	Start  int64   `bson:"_id"` // milisegundos Unix
+	CPU    float64 `bson:"cpu"`
+	Memory float64 `bson:"memory"`
+	RxMin  int64   `bson:"rxMin"`
+	RxMax  int64   `bson:"rxMax"`
+	TxMin  int64   `bson:"txMin"`
+	TxMax  int64   `bson:"txMax"`
+}
+
+// GetMetrics agrupa las muestras del servicio entre from y to en intervalos de step,
+// alineados a la época Unix. CPU y memoria son promedios; la red son los bytes
+// transferidos en cada intervalo.
+func (s *store) GetMetrics(userID string, name ServiceName, from, to time.Time, step time.Duration) ([]metricBucket, error) {
+	s, end := s.trace("store.GetMetrics")
+	defer end()
+	collection := s.database.Collection(metricsCollection)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+
+	stepMs := step.Milliseconds()
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"meta.userId":        userID,
+			"meta.containerName": name,
+			"ts":                 bson.M{"$gte": from, "$lt": to},
+		}},
+		bson.M{"$set": bson.M{"ms": bson.M{"$toLong": "$ts"}}},
+		bson.M{"$group": bson.M{
+			"_id":    bson.M{"$subtract": bson.A{"$ms", bson.M{"$mod": bson.A{"$ms", stepMs}}}},
+			"cpu":    bson.M{"$avg": "$cpuPercent"},
+			"memory": bson.M{"$avg": "$memoryBytes"},
+			"rxMin":  bson.M{"$min": "$networkRx"},
+			"rxMax":  bson.M{"$max": "$networkRx"},
+			"txMin":  bson.M{"$min": "$networkTx"},
+			"txMax":  bson.M{"$max": "$networkTx"},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate metrics: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	buckets := []metricBucket{}
+	if err := cur.All(ctx, &buckets); err != nil {
+		return nil, fmt.Errorf("failed to decode metrics: %w", err)
+	}
+	return buckets, nil
+}
+
+// counterDelta es lo que avanzó un contador acumulado desde prev; si el contenedor
+// se reinició el contador vuelve a cero y se cuenta desde ahí
+func counterDelta(prev, cur int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func StartMetricsSampler(ctx context.Context, s *store, interval time.Duration) {
+	if interval <= 0 {
+		metricsLog.Info("desactivado")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sampler, span := startSpan(s, "metrics.sample")
+			if err := sampler.SampleMetrics(trace.ContextWithSpan(ctx, span)); err != nil {
+				metricsLog.Error("error guardando muestras", "error", err)
+			}
+			span.End()
+		case <-ctx.Done():
+			metricsLog.Info("bucle detenido")
+			return
+		}
+	}
+}
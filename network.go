@@ -0,0 +1,257 @@
+package main
+
+//Redes aisladas por usuario. Cada usuario tiene su propio bridge al que se
+//conecta Traefik para el tráfico de entrada; MongoDB y backend-network quedan
+//fuera de su alcance.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const platformNetwork = "backend-network"
+
+var (
+	ingressContainer  = GetEnv("TRAEFIK_CONTAINER", "traefik")
+	platformContainer = GetEnv("PLATFORM_CONTAINER", "api") // solo para sacarla de redes antiguas
+
+	defaultNetworkPolicy = NetworkPolicy{
+		Egress:           GetEnv("NETWORK_DEFAULT_EGRESS", "true") == "true",
+		ServiceToService: GetEnv("NETWORK_DEFAULT_SERVICE_TO_SERVICE", "true") == "true",
+	}
+)
+
+// tenantSlug identifica al usuario en nombres de Docker sin exponer su ID
+func tenantSlug(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:6])
+}
+
+// tenantNetworkName depende de la política, así un cambio de política produce una
+// red nueva y el contenedor se mueve a ella con el siguiente despliegue.
+func tenantNetworkName(userID string, serviceName ServiceName, policy NetworkPolicy) string {
+	name := "tenant-" + tenantSlug(userID)
+	if !policy.ServiceToService {
+		name += "-" + string(serviceName)
+	}
+	if !policy.Egress {
+		name += "-internal"
+	}
+	return name
+}
+
+// GetNetworkPolicy devuelve la política del usuario o defaultNetworkPolicy
+func (s *store) GetNetworkPolicy(userID string) (NetworkPolicy, error) {
+	s, end := s.trace("store.GetNetworkPolicy")
+	defer end()
+	collection := s.database.Collection("networks")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var policy NetworkPolicy
+	err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&policy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			policy = defaultNetworkPolicy
+			policy.UserID = userID
+			return policy, nil
+		}
+		return NetworkPolicy{}, fmt.Errorf("failed to fetch network policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+func (s *store) SaveNetworkPolicy(policy NetworkPolicy) error {
+	s, end := s.trace("store.SaveNetworkPolicy")
+	defer end()
+	collection := s.database.Collection("networks")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	policy.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	if _, err := collection.ReplaceOne(ctx, bson.M{"userId": policy.UserID}, policy, opts); err != nil {
+		return fmt.Errorf("failed to save network policy: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureTenantNetwork crea la red del servicio según la política del usuario y
+// conecta Traefik. La API queda fuera para no exponerla a los servicios.
+// Devuelve el nombre de la red.
+func (s *store) EnsureTenantNetwork(userID string, serviceName ServiceName) (string, error) {
+	s, end := s.trace("store.EnsureTenantNetwork")
+	defer end()
+	policy, err := s.GetNetworkPolicy(userID)
+	if err != nil {
+		return "", err
+	}
+
+	name := tenantNetworkName(userID, serviceName, policy)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 30*time.Second)
+	defer cancel()
+
+	info, err := s.client.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		if !client.IsErrNotFound(err) {
+			return "", fmt.Errorf("failed to inspect network %s: %w", name, err)
+		}
+
+		// Una red interna no tiene salida a internet
+		_, err = s.client.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver:   "bridge",
+			Internal: !policy.Egress,
+			Labels: map[string]string{
+				"plataforma.tenant": tenantSlug(userID),
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create network %s: %w", name, err)
+		}
+		networkLog.InfoContext(ctx, "red creada", "network", name)
+	}
+
+	if err := s.connectPlatformContainer(ctx, name, ingressContainer, info); err != nil {
+		return "", err
+	}
+
+	// Versiones anteriores también conectaban la API
+	for _, endpoint := range info.Containers {
+		if endpoint.Name != platformContainer {
+			continue
+		}
+		if err := s.client.NetworkDisconnect(ctx, name, platformContainer, true); err != nil && !client.IsErrNotFound(err) {
+			return "", fmt.Errorf("failed to disconnect %s from %s: %w", platformContainer, name, err)
+		}
+		networkLog.InfoContext(ctx, "api desconectada de la red", "network", name)
+	}
+
+	return name, nil
+}
+
+// connectPlatformContainer conecta un contenedor de la plataforma a la red si no lo
+// está. Si el contenedor no existe (p. ej. la API corre fuera de Docker) se ignora.
+func (s *store) connectPlatformContainer(ctx context.Context, networkName, containerName string, info network.Inspect) error {
+	for _, endpoint := range info.Containers {
+		if endpoint.Name == containerName {
+			return nil
+		}
+	}
+
+	err := s.client.NetworkConnect(ctx, networkName, containerName, nil)
+	if err == nil || strings.Contains(err.Error(), "already exists") {
+		return nil
+	}
+	if client.IsErrNotFound(err) {
+		networkLog.WarnContext(ctx, "contenedor de la plataforma no encontrado, no se conecta a la red", "container", containerName, "network", networkName)
+		return nil
+	}
+	return fmt.Errorf("failed to connect %s to %s: %w", containerName, networkName, err)
+}
+
+// PruneTenantNetworks elimina las redes del usuario que ya no usa ningún servicio
+func (s *store) PruneTenantNetworks(userID string) error {
+	s, end := s.trace("store.PruneTenantNetworks")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 30*time.Second)
+	defer cancel()
+
+	networks, err := s.client.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", "plataforma.tenant="+tenantSlug(userID))),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	for _, n := range networks {
+		// Incluye contenedores detenidos, que volverían a necesitar la red al iniciar
+		users, err := s.client.ContainerList(ctx, container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("network", n.ID)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list containers of %s: %w", n.Name, err)
+		}
+
+		inUse := false
+		for _, c := range users {
+			if !isPlatformContainer(c.Names) {
+				inUse = true
+				break
+			}
+		}
+		if inUse {
+			continue
+		}
+
+		for _, c := range []string{ingressContainer, platformContainer} {
+			if err := s.client.NetworkDisconnect(ctx, n.ID, c, true); err != nil && !client.IsErrNotFound(err) && !strings.Contains(err.Error(), "is not connected") {
+				networkLog.ErrorContext(ctx, "error desconectando de la red", "container", c, "network", n.Name, "error", err)
+			}
+		}
+		if err := s.client.NetworkRemove(ctx, n.ID); err != nil && !client.IsErrNotFound(err) {
+			networkLog.ErrorContext(ctx, "error eliminando red", "network", n.Name, "error", err)
+			continue
+		}
+		networkLog.InfoContext(ctx, "red eliminada", "network", n.Name)
+	}
+
+	return nil
+}
+
+func isPlatformContainer(names []string) bool {
+	for _, n := range names {
+		n = strings.TrimPrefix(n, "/")
+		if n == ingressContainer || n == platformContainer {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateServiceContainers reemplaza los servicios creados antes de aislar las
+// redes (siguen en backend-network) o antes de las rutas /{tenant}/{servicio}.
+func MigrateServiceContainers(s *store) {
+	s, span := startSpan(s, "migrate.serviceContainers")
+	defer span.End()
+
+	records, err := s.GetAllContainers()
+	if err != nil {
+		migrateLog.ErrorContext(s.baseContext(), "error leyendo containers", "error", err)
+		return
+	}
+
+	for _, rec := range records {
+		if !rec.Status {
+			continue
+		}
+		info, err := s.client.ContainerInspect(s.baseContext(), rec.DockerName)
+		if err != nil {
+			continue
+		}
+		onPlatform := info.NetworkSettings != nil && info.NetworkSettings.Networks[platformNetwork] != nil
+		if !onPlatform && info.Config.Labels["plataforma.path"] == rec.Path {
+			continue
+		}
+
+		if _, err := s.RestartWithConfig(&rec); err != nil {
+			migrateLog.ErrorContext(s.baseContext(), "no se pudo reemplazar el contenedor", "containerName", rec.ContainerName, "error", err)
+			continue
+		}
+		migrateLog.InfoContext(s.baseContext(), "contenedor reemplazado", "containerName", rec.ContainerName, "path", rec.Path)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+//Métricas de Prometheus de la propia plataforma: peticiones HTTP, builds,
+//despliegues, el reconciliador, la verificación de tokens y los errores de
+//MongoDB y Docker. Se exponen en GET /metrics.
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metricsToken protege /metrics con "Authorization: Bearer <token>"; vacío lo deja abierto
+var metricsToken = GetEnv("METRICS_TOKEN", "")
+
+var (
+	promRegistry = prometheus.NewRegistry()
+	promFactory  = promauto.With(promRegistry)
+
+	httpRequests = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "plataforma_http_requests_total",
+		Help: "Peticiones HTTP por ruta, método y código de respuesta.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "plataforma_http_request_duration_seconds",
+		Help:    "Latencia de las peticiones HTTP por ruta y método.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	buildDuration = promFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "plataforma_build_duration_seconds",
+		Help:    "Duración de los builds de imágenes por resultado (success, invalid o error).",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11), // 1s a ~17m
+	}, []string{"outcome"})
+	deploys = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "plataforma_deploys_total",
+		Help: "Despliegues por tipo (create o redeploy) y resultado (success o error).",
+	}, []string{"kind", "outcome"})
+
+	reconcilerRuns = promFactory.NewCounter(prometheus.CounterOpts{
+		Name: "plataforma_reconciler_runs_total",
+		Help: "Pasadas del reconciliador que arranca los contenedores detenidos.",
+	})
+	reconcilerRepairs = promFactory.NewCounter(prometheus.CounterOpts{
+		Name: "plataforma_reconciler_repairs_total",
+		Help: "Contenedores que el reconciliador tuvo que volver a arrancar.",
+	})
+
+	authDuration = promFactory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "plataforma_auth_verification_duration_seconds",
+		Help:    "Latencia de la verificación de tokens contra el servicio de autenticación.",
+		Buckets: prometheus.DefBuckets,
+	})
+	authFailures = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "plataforma_auth_verification_failures_total",
+		Help: "Verificaciones de token fallidas por motivo (request, status o decode).",
+	}, []string{"reason"})
+
+	mongoErrors = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "plataforma_mongo_errors_total",
+		Help: "Comandos de MongoDB fallidos por comando.",
+	}, []string{"command"})
+	dockerErrors = promFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "plataforma_docker_errors_total",
+		Help: "Llamadas a la API de Docker fallidas (error de conexión o 5xx) por operación.",
+	}, []string{"operation"})
+)
+
+func init() {
+	promRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterStoreMetrics agrega las métricas que se leen de MongoDB en cada scrape
+func RegisterStoreMetrics(s *store) {
+	promRegistry.MustRegister(containerStateCollector{s})
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func observeBuild(started time.Time, err error) {
+	result := outcome(err)
+	if errors.Is(err, errInvalidBuildInput) {
+		result = "invalid"
+	}
+	buildDuration.WithLabelValues(result).Observe(time.Since(started).Seconds())
+}
+
+// containerStateCollector cuenta los contenedores administrados por estado:
+// running, unhealthy (corriendo pero sin pasar la sonda) o stopped
+type containerStateCollector struct {
+	s *store
+}
+
+var containerStateDesc = prometheus.NewDesc(
+	"plataforma_containers",
+	"Contenedores administrados por estado (running, unhealthy o stopped).",
+	[]string{"state"}, nil,
+)
+
+func (c containerStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containerStateDesc
+}
+
+func (c containerStateCollector) Collect(ch chan<- prometheus.Metric) {
+	records, err := c.s.GetAllContainers()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(containerStateDesc, err)
+		return
+	}
+
+	counts := map[string]int{"running": 0, "unhealthy": 0, "stopped": 0}
+	for _, rec := range records {
+		switch {
+		case !rec.Status:
+			counts["stopped"]++
+		case rec.Health != nil && rec.Health.Status == "unhealthy":
+			counts["unhealthy"]++
+		default:
+			counts["running"]++
+		}
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(containerStateDesc, prometheus.GaugeValue, float64(n), state)
+	}
+}
+
+// metricsHandler sirve /metrics, con METRICS_TOKEN si está configurado
+func metricsHandler() http.Handler {
+	next := promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{Registry: promRegistry})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if metricsToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) != 1 {
+				PermissionDenied(w)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder guarda el código de respuesta; Unwrap deja que
+// http.ResponseController llegue a Flush para los endpoints que hacen streaming
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrumentHTTP mide cada petición por el patrón con el que se registró la ruta
+// (por ejemplo "GET /containers/{name}"), no por la URL, para no crear una serie
+// por cada nombre de servicio
+func instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(path))
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+	})
+}
+
+// instrumentDocker envuelve el transporte HTTP del cliente de Docker para contar
+// errores y crear un span por llamada. Debe ir después de client.FromEnv, que
+// configura el transporte original.
+func instrumentDocker() client.Opt {
+	return func(c *client.Client) error {
+		hc := c.HTTPClient()
+		hc.Transport = dockerTransport{next: otelhttp.NewTransport(hc.Transport,
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return "docker " + dockerOperation(r)
+			}),
+		)}
+		return client.WithHTTPClient(hc)(c)
+	}
+}
+
+type dockerTransport struct {
+	next http.RoundTripper
+}
+
+func (t dockerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	// Los 4xx (contenedor inexistente, conflicto) son respuestas esperadas
+	if err != nil || resp.StatusCode >= 500 {
+		dockerErrors.WithLabelValues(dockerOperation(req)).Inc()
+	}
+	return resp, err
+}
+
+var (
+	dockerVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+	// Último segmento de las rutas de Docker que es una acción y no un ID o nombre
+	dockerActions = map[string]bool{
+		"json": true, "create": true, "start": true, "stop": true, "restart": true, "kill": true,
+		"wait": true, "logs": true, "stats": true, "rename": true, "update": true, "connect": true,
+		"disconnect": true, "tag": true, "prune": true, "push": true, "history": true, "_ping": true,
+	}
+)
+
+// dockerOperation resume la petición como "GET /containers/{id}/json"
+func dockerOperation(req *http.Request) string {
+	path := dockerVersionPrefix.ReplaceAllString(req.URL.Path, "")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		if !dockerActions[parts[i]] {
+			parts[i] = "{id}"
+		}
+	}
+	return req.Method + " /" + strings.Join(parts, "/")
+}
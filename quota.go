@@ -0,0 +1,417 @@
+package main
+
+//Límites de recursos por contenedor y cuotas por usuario
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// defaultPlan aplica a todos los usuarios sin un plan propio en la colección "plans"
+var defaultPlan = Plan{
+	Name: "default",
+	Defaults: ResourceLimits{
+		CPUs:          GetEnvFloat("PLAN_DEFAULT_CPUS", 0.5),
+		MemoryMB:      int64(GetEnvInt("PLAN_DEFAULT_MEMORY_MB", 256)),
+		PidsLimit:     int64(GetEnvInt("PLAN_DEFAULT_PIDS", 128)),
+		RestartPolicy: GetEnv("PLAN_DEFAULT_RESTART_POLICY", "on-failure"),
+		MaxRestarts:   GetEnvInt("PLAN_DEFAULT_MAX_RESTARTS", 5),
+	},
+	Max: ResourceLimits{
+		CPUs:        GetEnvFloat("PLAN_MAX_CPUS", 1),
+		MemoryMB:    int64(GetEnvInt("PLAN_MAX_MEMORY_MB", 512)),
+		PidsLimit:   int64(GetEnvInt("PLAN_MAX_PIDS", 256)),
+		MaxRestarts: GetEnvInt("PLAN_MAX_RESTARTS", 10),
+	},
+	MaxContainers:    GetEnvInt("PLAN_MAX_CONTAINERS", 5),
+	MaxTotalMemoryMB: int64(GetEnvInt("PLAN_MAX_TOTAL_MEMORY_MB", 1024)),
+	MaxBuildsPerDay:  GetEnvInt("PLAN_MAX_BUILDS_PER_DAY", 20),
+	MaxVolumes:       GetEnvInt("PLAN_MAX_VOLUMES", 3),
+	MaxVolumeMB:      int64(GetEnvInt("PLAN_MAX_VOLUME_MB", 1024)),
+}
+
+// Resolve completa los límites pedidos con los valores por defecto del plan y
+// rechaza los que superan el máximo.
+func (p Plan) Resolve(req *ResourceLimits) (ResourceLimits, error) {
+	limits := p.Defaults
+	if req != nil {
+		if req.CPUs > 0 {
+			limits.CPUs = req.CPUs
+		}
+		if req.MemoryMB > 0 {
+			limits.MemoryMB = req.MemoryMB
+		}
+		if req.PidsLimit > 0 {
+			limits.PidsLimit = req.PidsLimit
+		}
+		if req.RestartPolicy != "" {
+			limits.RestartPolicy = req.RestartPolicy
+		}
+		if req.MaxRestarts > 0 {
+			limits.MaxRestarts = req.MaxRestarts
+		}
+	}
+
+	switch {
+	case limits.CPUs < 0 || limits.MemoryMB < 0 || limits.PidsLimit < 0 || limits.MaxRestarts < 0:
+		return limits, fmt.Errorf("resource limits must be positive")
+	case p.Max.CPUs > 0 && limits.CPUs > p.Max.CPUs:
+		return limits, fmt.Errorf("%w: cpus must be at most %g", errQuotaExceeded, p.Max.CPUs)
+	case p.Max.MemoryMB > 0 && limits.MemoryMB > p.Max.MemoryMB:
+		return limits, fmt.Errorf("%w: memoryMb must be at most %d", errQuotaExceeded, p.Max.MemoryMB)
+	case p.Max.PidsLimit > 0 && limits.PidsLimit > p.Max.PidsLimit:
+		return limits, fmt.Errorf("%w: pidsLimit must be at most %d", errQuotaExceeded, p.Max.PidsLimit)
+	case p.Max.MaxRestarts > 0 && limits.MaxRestarts > p.Max.MaxRestarts:
+		return limits, fmt.Errorf("%w: maxRestarts must be at most %d", errQuotaExceeded, p.Max.MaxRestarts)
+	}
+
+	switch container.RestartPolicyMode(limits.RestartPolicy) {
+	case container.RestartPolicyDisabled, container.RestartPolicyOnFailure,
+		container.RestartPolicyUnlessStopped, container.RestartPolicyAlways:
+	default:
+		return limits, fmt.Errorf("unknown restart policy %q", limits.RestartPolicy)
+	}
+
+	return limits, nil
+}
+
+// hostConfig traduce los límites a la configuración de Docker
+func (l ResourceLimits) hostConfig(hc *container.HostConfig) {
+	hc.Resources.NanoCPUs = int64(l.CPUs * 1e9)
+	hc.Resources.Memory = l.MemoryMB << 20
+	if l.PidsLimit > 0 {
+		pids := l.PidsLimit
+		hc.Resources.PidsLimit = &pids
+	}
+
+	hc.RestartPolicy = container.RestartPolicy{Name: container.RestartPolicyMode(l.RestartPolicy)}
+	if hc.RestartPolicy.Name == container.RestartPolicyOnFailure {
+		hc.RestartPolicy.MaximumRetryCount = l.MaxRestarts
+	}
+}
+
+// GetPlan devuelve el plan asignado al usuario o defaultPlan
+func (s *store) GetPlan(userID string) (Plan, error) {
+	s, end := s.trace("store.GetPlan")
+	defer end()
+	collection := s.database.Collection("plans")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var plan Plan
+	err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&plan)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return defaultPlan, nil
+		}
+		return Plan{}, fmt.Errorf("failed to fetch plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+// userTotals es la suma que devuelve sumByUser
+type userTotals struct {
+	Count    int     `bson:"count"`
+	MemoryMB int64   `bson:"memoryMb"`
+	CPUs     float64 `bson:"cpus"`
+	SizeMB   int64   `bson:"sizeMb"`
+}
+
+// sumByUser cuenta y suma en MongoDB los documentos del filtro; fields asocia
+// cada campo de userTotals con la ruta del documento a sumar
+func (s *store) sumByUser(collection string, filter bson.M, fields bson.M) (userTotals, error) {
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	group := bson.M{"_id": nil, "count": bson.M{"$sum": 1}}
+	for field, path := range fields {
+		group[field] = bson.M{"$sum": path}
+	}
+
+	cur, err := s.database.Collection(collection).Aggregate(ctx, bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": group},
+	})
+	if err != nil {
+		return userTotals{}, fmt.Errorf("failed to sum %s: %w", collection, err)
+	}
+	defer cur.Close(ctx)
+
+	var totals userTotals
+	if cur.Next(ctx) {
+		if err := cur.Decode(&totals); err != nil {
+			return userTotals{}, fmt.Errorf("failed to decode %s totals: %w", collection, err)
+		}
+	}
+	return totals, cur.Err()
+}
+
+// containerTotals suma los contenedores del usuario
+func (s *store) containerTotals(userID string) (userTotals, error) {
+	return s.sumByUser("containers", bson.M{"userId": userID}, bson.M{"memoryMb": "$resources.memoryMb", "cpus": "$resources.cpus"})
+}
+
+// GetUsage calcula el consumo del usuario a partir de MongoDB, sin consultar Docker
+func (s *store) GetUsage(userID string) (Usage, error) {
+	s, end := s.trace("store.GetUsage")
+	defer end()
+	containers, err := s.containerTotals(userID)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{Containers: containers.Count, MemoryMB: containers.MemoryMB, CPUs: containers.CPUs}
+
+	collection := s.database.Collection("counters")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var builds struct {
+		Count int `bson:"count"`
+	}
+	err = collection.FindOne(ctx, bson.M{"_id": buildCounterID(userID)}).Decode(&builds)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return Usage{}, fmt.Errorf("failed to count builds: %w", err)
+	}
+	usage.BuildsToday = builds.Count
+
+	volumes, err := s.sumByUser("volumes", bson.M{"userId": userID}, bson.M{"sizeMb": "$sizeMb"})
+	if err != nil {
+		return Usage{}, err
+	}
+	usage.Volumes = volumes.Count
+	usage.VolumeMB = volumes.SizeMB
+
+	return usage, nil
+}
+
+const reservationsCollection = "quota_reservations"
+
+// quotaReservationTTL cubre el build y el despliegue de una edición
+var quotaReservationTTL = GetEnvDuration("QUOTA_RESERVATION_TTL", 30*time.Minute)
+
+// containerReservation aparta cupo para un despliegue en curso. Caduca sola por
+// si la API se cae antes de liberarla.
+type containerReservation struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	UserID        string             `bson:"userId"`
+	ContainerName ServiceName        `bson:"containerName"`
+	MemoryMB      int64              `bson:"memoryMb"`
+	ExpiresAt     time.Time          `bson:"expiresAt"`
+}
+
+// ReserveContainer aparta el lugar y la memoria de un contenedor antes de tocar
+// Docker; name es el servicio que se crea o se reemplaza. Primero se inserta la
+// reserva y después se suma todo lo del usuario, incluidas las reservas de otros
+// pedidos en curso: si dos pedidos compiten por el último cupo, al menos uno ve
+// al otro y falla, así que el plan nunca se excede. La función devuelta libera la
+// reserva y debe llamarse después de guardar el contenedor.
+func (s *store) ReserveContainer(userID string, plan Plan, limits ResourceLimits, name ServiceName) (func(), error) {
+	s, end := s.trace("store.ReserveContainer")
+	defer end()
+	collection := s.database.Collection(reservationsCollection)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	own := containerReservation{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		ContainerName: name,
+		MemoryMB:      limits.MemoryMB,
+		ExpiresAt:     now.Add(quotaReservationTTL),
+	}
+	if _, err := collection.InsertOne(ctx, own); err != nil {
+		return nil, fmt.Errorf("failed to reserve quota: %w", err)
+	}
+	release := func() {
+		ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+		defer cancel()
+		if _, err := collection.DeleteOne(ctx, bson.M{"_id": own.ID}); err != nil {
+			httpLog.ErrorContext(ctx, "error liberando la reserva de cuota", "containerName", name, "error", err)
+		}
+	}
+
+	if err := s.checkReservations(ctx, userID, plan, now); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// checkReservations suma los contenedores guardados y las reservas vigentes del
+// usuario. Un servicio con reserva cuenta por la reserva y no por su registro, así
+// una edición no suma dos veces la memoria del mismo servicio.
+func (s *store) checkReservations(ctx context.Context, userID string, plan Plan, now time.Time) error {
+	cur, err := s.database.Collection(reservationsCollection).Find(ctx,
+		bson.M{"userId": userID, "expiresAt": bson.M{"$gt": now}},
+		options.Find().SetLimit(maxPageSize),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read quota reservations: %w", err)
+	}
+	var reservations []containerReservation
+	if err := cur.All(ctx, &reservations); err != nil {
+		return fmt.Errorf("failed to read quota reservations: %w", err)
+	}
+
+	reserved := map[ServiceName]bool{}
+	var memory int64
+	for _, r := range reservations {
+		reserved[r.ContainerName] = true
+		memory += r.MemoryMB
+	}
+	names := bson.A{}
+	for n := range reserved {
+		names = append(names, n)
+	}
+
+	totals, err := s.sumByUser("containers",
+		bson.M{"userId": userID, "containerName": bson.M{"$nin": names}},
+		bson.M{"memoryMb": "$resources.memoryMb"},
+	)
+	if err != nil {
+		return err
+	}
+	count := totals.Count + len(reserved)
+	memory += totals.MemoryMB
+
+	if plan.MaxContainers > 0 && count > plan.MaxContainers {
+		return fmt.Errorf("%w: plan allows %d containers", errQuotaExceeded, plan.MaxContainers)
+	}
+	if plan.MaxTotalMemoryMB > 0 && memory > plan.MaxTotalMemoryMB {
+		return fmt.Errorf("%w: plan allows %d MB of memory in total, %d MB requested", errQuotaExceeded, plan.MaxTotalMemoryMB, memory)
+	}
+	return nil
+}
+
+// EnsureQuotaIndexes borra solas las reservas caducadas
+func (s *store) EnsureQuotaIndexes() error {
+	s, end := s.trace("store.EnsureQuotaIndexes")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.database.Collection(reservationsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "expiresAt", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create quota indexes: %w", err)
+	}
+	return nil
+}
+
+// CheckBuildQuota es una comprobación temprana para no preparar un build que no
+// cabe; el cupo se reserva recién en ReserveBuild
+func (s *store) CheckBuildQuota(userID string) error {
+	s, end := s.trace("store.CheckBuildQuota")
+	defer end()
+	plan, err := s.GetPlan(userID)
+	if err != nil {
+		return err
+	}
+	if plan.MaxBuildsPerDay <= 0 {
+		return nil
+	}
+
+	usage, err := s.GetUsage(userID)
+	if err != nil {
+		return err
+	}
+	if usage.BuildsToday >= plan.MaxBuildsPerDay {
+		return fmt.Errorf("%w: plan allows %d builds per day", errQuotaExceeded, plan.MaxBuildsPerDay)
+	}
+
+	return nil
+}
+
+// buildCounterID identifica el contador de builds del usuario para el día en curso (UTC)
+func buildCounterID(userID string) string {
+	return "builds:" + userID + ":" + startOfDay().Format("2006-01-02")
+}
+
+// ReserveBuild ocupa un build del cupo diario antes de construir. El $inc solo se
+// aplica si el contador no llegó al límite; si ya llegó, el upsert choca con el
+// documento existente y el build se rechaza, así dos pedidos simultáneos no pueden
+// pasar ambos con el último cupo.
+func (s *store) ReserveBuild(userID string, containerName ServiceName) error {
+	s, end := s.trace("store.ReserveBuild")
+	defer end()
+	plan, err := s.GetPlan(userID)
+	if err != nil {
+		return err
+	}
+
+	collection := s.database.Collection("counters")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": buildCounterID(userID)}
+	if plan.MaxBuildsPerDay > 0 {
+		filter["count"] = bson.M{"$lt": plan.MaxBuildsPerDay}
+	}
+	_, err = collection.UpdateOne(ctx, filter, bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$set":         bson.M{"lastContainerName": containerName, "updatedAt": time.Now()},
+		"$setOnInsert": bson.M{"userId": userID},
+	}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: plan allows %d builds per day", errQuotaExceeded, plan.MaxBuildsPerDay)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reserve build: %w", err)
+	}
+
+	return nil
+}
+
+func (s *store) UpdateContainerResources(userID string, containerName ServiceName, limits ResourceLimits) error {
+	s, end := s.trace("store.UpdateContainerResources")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"resources": limits,
+			"updatedAt": time.Now(),
+		},
+	}
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update container resources: %w", err)
+	}
+
+	return nil
+}
+
+// writeQuotaError responde 403 si se excedió una cuota y status en otro caso
+func writeQuotaError(w http.ResponseWriter, err error, status int) {
+	if errors.Is(err, errQuotaExceeded) {
+		WriteError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	WriteError(w, status, err.Error())
+}
+
+func startOfDay() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,544 @@
+package main
+
+//Versiones inmutables de cada servicio: cada build genera una release "<repo>:vN",
+//donde repo es el nombre interno del servicio (dockerServiceName)
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// releaseRetention es cuántas releases se conservan por servicio
+var releaseRetention = GetEnvInt("RELEASE_RETENTION", 5)
+
+// sourceArchiveDir guarda el código fuente de cada release, ya que los workspaces
+// de build se eliminan al terminar; vacío desactiva el archivo
+var sourceArchiveDir = GetEnv("SOURCE_ARCHIVE_DIR", "./archive")
+
+func releaseImage(repo string, version int) string {
+	return fmt.Sprintf("%s:v%d", repo, version)
+}
+
+// nextReleaseVersion incrementa de forma atómica el contador de versiones del servicio
+func (s *store) nextReleaseVersion(userID string, name ServiceName) (int, error) {
+	collection := s.database.Collection("counters")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": "release:" + userID + "/" + string(name)},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		opts,
+	).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate release version: %w", err)
+	}
+
+	return counter.Seq, nil
+}
+
+// BuildRelease construye el workspace como una nueva release del servicio,
+// etiquetada como "<repo>:vN", y la registra en MongoDB. "<repo>:latest" se mueve
+// con PromoteRelease cuando la release queda desplegada.
+func (s *store) BuildRelease(userID string, name ServiceName, workspaceDir string, opts BuildOptions) (*Release, error) {
+	s, end := s.trace("store.BuildRelease")
+	defer end()
+	// Un Dockerfile que falta o que la política rechaza no gasta un build del cupo
+	dockerfile, err := readContextFile(filepath.Join(workspaceDir, "Dockerfile"))
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo Dockerfile: %w", err)
+	}
+	if err := buildPolicy.CheckDockerfile(dockerfile, opts.Target); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBuildInput, err)
+	}
+
+	if err := s.ReserveBuild(userID, name); err != nil {
+		return nil, err
+	}
+
+	version, err := s.nextReleaseVersion(userID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := dockerServiceName(userID, name)
+	tag := releaseImage(repo, version)
+	started := time.Now()
+	checksum, err := s.BuildContainerImage(workspaceDir, []string{tag}, opts)
+	observeBuild(started, err)
+	if err != nil {
+		s.FireAlerts(userID, name, "build_failed", 0, err.Error(), map[string]any{"version": version})
+		s.recordHistory(ContainerUpdate{
+			UserID:        userID,
+			ContainerName: name,
+			Event:         HistoryBuildFailed,
+			Actor:         userID,
+			Reason:        err.Error(),
+			Release:       version,
+		})
+		return nil, err
+	}
+
+	info, err := s.client.ImageInspect(s.baseContext(), tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", tag, err)
+	}
+
+	archive, err := archiveSource(workspaceDir, repo, version)
+	if err != nil {
+		// La release sigue siendo válida sin el archivo del código fuente
+		buildLog.WarnContext(s.baseContext(), "error archivando el código fuente", "image", tag, "error", err)
+	}
+
+	release := Release{
+		UserID:         userID,
+		ContainerName:  name,
+		Version:        version,
+		Image:          tag,
+		Digest:         info.ID,
+		SourceChecksum: checksum,
+		Runtime:        dockerfileRuntime(dockerfile, opts.Target),
+		CommitSHA:      opts.Labels[commitLabel],
+		SourceArchive:  archive,
+		BuildMillis:    time.Since(started).Milliseconds(),
+		CreatedAt:      time.Now(),
+	}
+
+	if err := s.SaveRelease(release); err != nil {
+		return nil, err
+	}
+
+	if err := s.PruneReleases(userID, name); err != nil {
+		buildLog.ErrorContext(s.baseContext(), "error limpiando releases", "containerName", name, "error", err)
+	}
+
+	s.recordHistory(ContainerUpdate{
+		UserID:        userID,
+		ContainerName: name,
+		Event:         HistoryBuilt,
+		Actor:         userID,
+		Release:       release.Version,
+	})
+
+	s.PublishEvent(userID, EventImageBuilt, ServiceEvent{
+		ContainerName: name,
+		Release:       release.Version,
+		Image:         release.Image,
+		Actor:         userID,
+	})
+
+	return &release, nil
+}
+
+// archiveSource guarda el contexto del build como "<sourceArchiveDir>/<repo>/vN.tar.gz"
+// y devuelve la ruta, o "" si el archivo está desactivado
+func archiveSource(workspaceDir, repo string, version int) (string, error) {
+	if sourceArchiveDir == "" {
+		return "", nil
+	}
+
+	dir, err := safeJoin(sourceArchiveDir, repo)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("v%d.tar.gz", version))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	gz := gzip.NewWriter(f)
+	err = writeContextTar(gz, workspaceDir)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (s *store) SaveRelease(release Release) error {
+	s, end := s.trace("store.SaveRelease")
+	defer end()
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, release); err != nil {
+		return fmt.Errorf("failed to save release: %w", err)
+	}
+
+	return nil
+}
+
+// RenameReleases pasa las releases de una imagen construida con otro nombre al
+// servicio que la despliega, junto con su contador de versiones. Las imágenes
+// conservan su tag original.
+func (s *store) RenameReleases(userID string, from, to ServiceName) error {
+	s, end := s.trace("store.RenameReleases")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"userId": userID, "containerName": from}
+	if _, err := s.database.Collection("releases").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"containerName": to}}); err != nil {
+		return fmt.Errorf("failed to rename releases: %w", err)
+	}
+
+	counters := s.database.Collection("counters")
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := counters.FindOneAndDelete(ctx, bson.M{"_id": "release:" + userID + "/" + string(from)}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename release counter: %w", err)
+	}
+
+	// $max evita reutilizar versiones si el servicio ya tenía releases propias
+	_, err = counters.UpdateOne(ctx,
+		bson.M{"_id": "release:" + userID + "/" + string(to)},
+		bson.M{"$max": bson.M{"seq": counter.Seq}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rename release counter: %w", err)
+	}
+
+	return nil
+}
+
+// GetReleases devuelve las releases del servicio, de la más nueva a la más vieja
+func (s *store) GetReleases(userID string, name ServiceName) ([]Release, error) {
+	s, end := s.trace("store.GetReleases")
+	defer end()
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": name,
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query releases: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	results := []Release{}
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+
+	return results, nil
+}
+
+func (s *store) GetRelease(userID string, name ServiceName, version int) (*Release, error) {
+	s, end := s.trace("store.GetRelease")
+	defer end()
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": name,
+		"version":       version,
+	}
+
+	var release Release
+	if err := collection.FindOne(ctx, filter).Decode(&release); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch release: %w", err)
+	}
+
+	return &release, nil
+}
+
+func (s *store) GetLatestRelease(userID string, name ServiceName) (*Release, error) {
+	s, end := s.trace("store.GetLatestRelease")
+	defer end()
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": name,
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	var release Release
+	if err := collection.FindOne(ctx, filter, opts).Decode(&release); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch latest release: %w", err)
+	}
+
+	return &release, nil
+}
+
+// GetContainer devuelve el registro del contenedor del usuario, o nil si no existe
+func (s *store) GetContainer(userID string, containerName ServiceName) (*ContainerRecord, error) {
+	s, end := s.trace("store.GetContainer")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	var rec ContainerRecord
+	if err := collection.FindOne(ctx, filter).Decode(&rec); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch container: %w", err)
+	}
+
+	return &rec, nil
+}
+
+// GetContainerByDockerName busca el registro por el nombre del contenedor en Docker
+func (s *store) GetContainerByDockerName(dockerName string) (*ContainerRecord, error) {
+	s, end := s.trace("store.GetContainerByDockerName")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var rec ContainerRecord
+	if err := collection.FindOne(ctx, bson.M{"dockerName": dockerName}).Decode(&rec); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch container: %w", err)
+	}
+
+	return &rec, nil
+}
+
+func (s *store) UpdateContainerRelease(userID string, containerName ServiceName, release *Release) error {
+	s, end := s.trace("store.UpdateContainerRelease")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"release":   release.Version,
+			"commitSha": release.CommitSHA,
+			"updatedAt": time.Now(),
+		},
+	}
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update container release: %w", err)
+	}
+
+	return nil
+}
+
+// PromoteRelease vuelve a etiquetar la release como "<repo>:latest" para que
+// los redeploys y el arranque periódico usen esa versión.
+func (s *store) PromoteRelease(release *Release) error {
+	s, end := s.trace("store.PromoteRelease")
+	defer end()
+	ctx := s.baseContext()
+
+	latest := dockerServiceName(release.UserID, release.ContainerName) + ":latest"
+	if err := s.client.ImageTag(ctx, release.Image, latest); err != nil {
+		if client.IsErrNotFound(err) {
+			return fmt.Errorf("image %s is no longer available", release.Image)
+		}
+		return fmt.Errorf("failed to tag %s as latest: %w", release.Image, err)
+	}
+
+	return nil
+}
+
+// PromoteIfUndeployed mueve "<repo>:latest" a la release solo si el servicio
+// todavía no existe; si ya corre, :latest sigue apuntando a lo desplegado.
+func (s *store) PromoteIfUndeployed(release *Release) error {
+	rec, err := s.GetContainer(release.UserID, release.ContainerName)
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		return nil
+	}
+	return s.PromoteRelease(release)
+}
+
+// releasesToPrune devuelve las releases que exceden releaseRetention, sin incluir
+// nunca la que está desplegada
+func (s *store) releasesToPrune(userID string, name ServiceName) ([]Release, error) {
+	if releaseRetention <= 0 {
+		return nil, nil
+	}
+
+	releases, err := s.GetReleases(userID, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) <= releaseRetention {
+		return nil, nil
+	}
+
+	deployed := 0
+	rec, err := s.GetContainer(userID, name)
+	if err != nil {
+		return nil, err
+	}
+	if rec != nil {
+		deployed = rec.Release
+	}
+
+	var prune []Release
+	for _, rel := range releases[releaseRetention:] {
+		if rel.Version != deployed {
+			prune = append(prune, rel)
+		}
+	}
+	return prune, nil
+}
+
+// PruneReleases elimina las releases que exceden releaseRetention, sin tocar
+// nunca la que está desplegada.
+func (s *store) PruneReleases(userID string, name ServiceName) error {
+	s, end := s.trace("store.PruneReleases")
+	defer end()
+	releases, err := s.releasesToPrune(userID, name)
+	if err != nil {
+		return err
+	}
+
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 30*time.Second)
+	defer cancel()
+
+	for _, rel := range releases {
+		_, err := s.client.ImageRemove(ctx, rel.Image, image.RemoveOptions{PruneChildren: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			// La imagen puede seguir en uso por un contenedor; se reintenta en la próxima poda
+			if !cerrdefs.IsConflict(err) {
+				buildLog.ErrorContext(ctx, "error eliminando imagen", "image", rel.Image, "error", err)
+			}
+			continue
+		}
+
+		filter := bson.M{
+			"userId":        userID,
+			"containerName": name,
+			"version":       rel.Version,
+		}
+		if _, err := collection.DeleteOne(ctx, filter); err != nil {
+			return fmt.Errorf("failed to delete release %d: %w", rel.Version, err)
+		}
+		removeSourceArchive(rel)
+	}
+
+	return nil
+}
+
+// DeleteReleases borra los registros y el código fuente de todas las releases de
+// un servicio eliminado. Sus imágenes quedan huérfanas y las elimina el GC.
+func (s *store) DeleteReleases(userID string, name ServiceName) error {
+	s, end := s.trace("store.DeleteReleases")
+	defer end()
+	releases, err := s.GetReleases(userID, name)
+	if err != nil {
+		return err
+	}
+
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": name,
+	}
+	if _, err := collection.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete releases: %w", err)
+	}
+
+	for _, rel := range releases {
+		removeSourceArchive(rel)
+	}
+	return nil
+}
+
+func removeSourceArchive(rel Release) {
+	if rel.SourceArchive == "" {
+		return
+	}
+	if err := os.Remove(rel.SourceArchive); err != nil && !os.IsNotExist(err) {
+		buildLog.Error("error eliminando el código fuente", "image", rel.Image, "error", err)
+	}
+}
+
+// GetAllReleases devuelve las releases de todos los usuarios
+func (s *store) GetAllReleases() ([]Release, error) {
+	s, end := s.trace("store.GetAllReleases")
+	defer end()
+	collection := s.database.Collection("releases")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query releases: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	results := []Release{}
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+
+	return results, nil
+}
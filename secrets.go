@@ -0,0 +1,311 @@
+package main
+
+//Variables de entorno y secretos por microservicio. Los secretos se cifran con
+//AES-GCM usando una llave de datos por servicio, que a su vez se guarda cifrada
+//con la llave maestra SECRETS_MASTER_KEY (cifrado de sobre).
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errSecretsDisabled = errors.New("secrets are not configured: set SECRETS_MASTER_KEY")
+	envKeyPattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+	reservedEnvKeys    = map[string]bool{"MICROSERVICIO_NAME": true, "MICROSERVICIO_PATH": true}
+)
+
+// masterKey se lee una sola vez; debe ser base64 de 32 bytes
+var masterKey = func() []byte {
+	raw := GetEnv("SECRETS_MASTER_KEY", "")
+	if raw == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		panic("SECRETS_MASTER_KEY must be 32 bytes encoded in base64")
+	}
+	return key
+}()
+
+func ValidateEnvKey(key string) error {
+	if !envKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid variable name %q", key)
+	}
+	if reservedEnvKeys[key] {
+		return fmt.Errorf("variable %s is reserved", key)
+	}
+	return nil
+}
+
+func seal(key, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func open(key []byte, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// dataKey descifra la llave de datos del servicio; se crea con ensureDataKey
+func (c *ServiceConfig) dataKey() ([]byte, error) {
+	if masterKey == nil {
+		return nil, errSecretsDisabled
+	}
+	if c.DataKey == "" {
+		return nil, fmt.Errorf("service has no data key")
+	}
+
+	key, err := open(masterKey, c.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	return key, nil
+}
+
+// newDataKey genera una llave de datos y la devuelve cifrada con la llave maestra
+func newDataKey() (string, error) {
+	if masterKey == nil {
+		return "", errSecretsDisabled
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return seal(masterKey, key)
+}
+
+// GetServiceConfig devuelve la configuración del servicio; nunca es nil
+func (s *store) GetServiceConfig(userID string, containerName ServiceName) (*ServiceConfig, error) {
+	s, end := s.trace("store.GetServiceConfig")
+	defer end()
+	collection := s.database.Collection("configs")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	cfg := &ServiceConfig{UserID: userID, ContainerName: containerName}
+	if err := collection.FindOne(ctx, filter).Decode(cfg); err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	if cfg.Vars == nil {
+		cfg.Vars = map[string]string{}
+	}
+	if cfg.Secrets == nil {
+		cfg.Secrets = map[string]string{}
+	}
+
+	return cfg, nil
+}
+
+// updateServiceConfig aplica update al documento del servicio, creándolo si no
+// existe. Si dos llamadas lo crean a la vez, el índice único hace fallar a una y
+// se reintenta como actualización.
+func (s *store) updateServiceConfig(userID string, containerName ServiceName, filter bson.M, update any) (*mongo.UpdateResult, error) {
+	collection := s.database.Collection("configs")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter["userId"] = userID
+	filter["containerName"] = containerName
+	opts := options.Update().SetUpsert(len(filter) == 2)
+
+	res, err := collection.UpdateOne(ctx, filter, update, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		res, err = collection.UpdateOne(ctx, filter, update)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	return res, nil
+}
+
+// ensureDataKey devuelve la llave de datos del servicio. La primera vez la guarda
+// solo si sigue sin haber una ($ifNull en el mismo update) y luego relee la que
+// quedó, así dos secretos guardados a la vez nunca usan llaves distintas.
+func (s *store) ensureDataKey(userID string, containerName ServiceName) ([]byte, error) {
+	cfg, err := s.GetServiceConfig(userID, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DataKey != "" {
+		return cfg.dataKey()
+	}
+
+	sealed, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	update := bson.A{bson.M{"$set": bson.M{"dataKey": bson.M{"$ifNull": bson.A{"$dataKey", sealed}}}}}
+	if _, err := s.updateServiceConfig(userID, containerName, bson.M{}, update); err != nil {
+		return nil, err
+	}
+
+	cfg, err = s.GetServiceConfig(userID, containerName)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.dataKey()
+}
+
+// configField es la ruta en MongoDB de una variable o un secreto
+func configField(key string, secret bool) string {
+	if secret {
+		return "secrets." + key
+	}
+	return "vars." + key
+}
+
+func (s *store) SetConfigVar(userID string, containerName ServiceName, key, value string) error {
+	s, end := s.trace("store.SetConfigVar")
+	defer end()
+	_, err := s.updateServiceConfig(userID, containerName, bson.M{}, bson.M{
+		"$set": bson.M{configField(key, false): value, "updatedAt": time.Now()},
+	})
+	return err
+}
+
+func (s *store) SetSecret(userID string, containerName ServiceName, key, value string) error {
+	s, end := s.trace("store.SetSecret")
+	defer end()
+	dataKey, err := s.ensureDataKey(userID, containerName)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	_, err = s.updateServiceConfig(userID, containerName, bson.M{}, bson.M{
+		"$set": bson.M{configField(key, true): sealed, "updatedAt": time.Now()},
+	})
+	return err
+}
+
+// DeleteConfigKey elimina una variable (secret=false) o un secreto; devuelve false si no existía
+func (s *store) DeleteConfigKey(userID string, containerName ServiceName, key string, secret bool) (bool, error) {
+	s, end := s.trace("store.DeleteConfigKey")
+	defer end()
+	field := configField(key, secret)
+	res, err := s.updateServiceConfig(userID, containerName, bson.M{field: bson.M{"$exists": true}}, bson.M{
+		"$unset": bson.M{field: ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// RestoreConfigKey deja una variable o un secreto como estaba: previous es el
+// valor guardado (cifrado, si es un secreto) o nil si no existía
+func (s *store) RestoreConfigKey(userID string, containerName ServiceName, key string, secret bool, previous *string) error {
+	s, end := s.trace("store.RestoreConfigKey")
+	defer end()
+	field := configField(key, secret)
+	update := bson.M{"$unset": bson.M{field: ""}, "$set": bson.M{"updatedAt": time.Now()}}
+	if previous != nil {
+		update = bson.M{"$set": bson.M{field: *previous, "updatedAt": time.Now()}}
+	}
+	_, err := s.updateServiceConfig(userID, containerName, bson.M{}, update)
+	return err
+}
+
+func (s *store) DeleteServiceConfig(userID string, containerName ServiceName) error {
+	s, end := s.trace("store.DeleteServiceConfig")
+	defer end()
+	collection := s.database.Collection("configs")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	if _, err := collection.DeleteOne(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete config: %w", err)
+	}
+
+	return nil
+}
+
+// ResolveEnv descifra la configuración del servicio como "CLAVE=valor", ordenada por clave
+func (s *store) ResolveEnv(userID string, containerName ServiceName) ([]string, error) {
+	s, end := s.trace("store.ResolveEnv")
+	defer end()
+	cfg, err := s.GetServiceConfig(userID, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	env := make([]string, 0, len(cfg.Vars)+len(cfg.Secrets))
+	for k, v := range cfg.Vars {
+		env = append(env, k+"="+v)
+	}
+
+	if len(cfg.Secrets) > 0 {
+		dataKey, err := cfg.dataKey()
+		if err != nil {
+			return nil, err
+		}
+		for k, sealed := range cfg.Secrets {
+			value, err := open(dataKey, sealed)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt secret %s: %w", k, err)
+			}
+			env = append(env, k+"="+string(value))
+		}
+	}
+
+	sort.Strings(env)
+	return env, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestSealOpen(t *testing.T) {
+	key := testKey(1)
+
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"empty", ""},
+		{"ascii", "postgres://user:pass@db/app"},
+		{"unicode", "contraseña-ñandú"},
+		{"binary", "\x00\xff\x10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := seal(key, []byte(tt.plaintext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.plaintext != "" && bytes.Contains([]byte(sealed), []byte(tt.plaintext)) {
+				t.Fatal("sealed value contains the plaintext")
+			}
+			got, err := open(key, sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.plaintext {
+				t.Fatalf("open() = %q, want %q", got, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshNonce(t *testing.T) {
+	key := testKey(1)
+	a, err := seal(key, []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seal(key, []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("sealing the same value twice produced the same ciphertext")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	key := testKey(1)
+	sealed, err := seal(key, []byte("secreto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		key    []byte
+		sealed string
+	}{
+		{"wrong key", testKey(2), sealed},
+		{"tampered", key, base64.StdEncoding.EncodeToString(tampered)},
+		{"too short", key, base64.StdEncoding.EncodeToString([]byte("abc"))},
+		{"not base64", key, "%%%"},
+		{"invalid key size", []byte("short"), sealed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := open(tt.key, tt.sealed); err == nil {
+				t.Fatalf("open() = %q, want error", got)
+			}
+		})
+	}
+}
+
+func TestServiceConfigDataKey(t *testing.T) {
+	saved := masterKey
+	defer func() { masterKey = saved }()
+
+	masterKey = nil
+	if _, err := (&ServiceConfig{}).dataKey(); !errors.Is(err, errSecretsDisabled) {
+		t.Fatalf("dataKey() without master key = %v, want errSecretsDisabled", err)
+	}
+	if _, err := newDataKey(); !errors.Is(err, errSecretsDisabled) {
+		t.Fatalf("newDataKey() without master key = %v, want errSecretsDisabled", err)
+	}
+
+	masterKey = testKey(7)
+	if _, err := (&ServiceConfig{}).dataKey(); err == nil {
+		t.Fatal("dataKey() without a stored key should fail instead of creating one")
+	}
+
+	sealed, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ServiceConfig{DataKey: sealed}
+	first, err := cfg.dataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cfg.dataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 32 || !bytes.Equal(first, second) {
+		t.Fatal("dataKey() must decrypt the same 32-byte key every time")
+	}
+
+	masterKey = testKey(8)
+	if _, err := cfg.dataKey(); err == nil {
+		t.Fatal("dataKey() with another master key should fail")
+	}
+}
+
+func TestValidateEnvKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"DATABASE_URL", true},
+		{"_private", true},
+		{"a1", true},
+		{"", false},
+		{"1ABC", false},
+		{"WITH-DASH", false},
+		{"secrets.x", false},
+		{"$set", false},
+		{"MICROSERVICIO_NAME", false},
+		{"MICROSERVICIO_PATH", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if err := ValidateEnvKey(tt.key); (err == nil) != tt.valid {
+				t.Fatalf("ValidateEnvKey(%q) = %v, valid = %v", tt.key, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestConfigField(t *testing.T) {
+	if got := configField("API_KEY", true); got != "secrets.API_KEY" {
+		t.Errorf("configField(secret) = %q", got)
+	}
+	if got := configField("PORT", false); got != "vars.PORT" {
+		t.Errorf("configField(var) = %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+//Perfil de seguridad con el que se crean los contenedores de los usuarios.
+//Las excepciones solo las puede otorgar un administrador, por contenedor.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	securityUser        = GetEnv("SECURITY_USER", "10001:10001")
+	securityReadOnly    = GetEnv("SECURITY_READ_ONLY_ROOTFS", "true") == "true"
+	securityTmpfsMB     = int64(GetEnvInt("SECURITY_TMPFS_MB", 64))
+	securityNofile      = int64(GetEnvInt("SECURITY_ULIMIT_NOFILE", 1024))
+	securitySeccompPath = GetEnv("SECURITY_SECCOMP_PROFILE", "") // vacío usa el perfil por defecto de Docker
+	volumeInitImage     = GetEnv("VOLUME_INIT_IMAGE", "busybox:latest")
+
+	// Capacidades que un administrador puede devolver a un contenedor
+	grantableCaps = GetEnvList("SECURITY_GRANTABLE_CAPS", []string{
+		"CHOWN", "DAC_OVERRIDE", "FOWNER", "KILL", "NET_BIND_SERVICE", "NET_RAW", "SETGID", "SETUID",
+	})
+
+	// seccompProfile es el contenido JSON del perfil; la API de Docker no acepta rutas
+	seccompProfile = func() string {
+		if securitySeccompPath == "" {
+			return ""
+		}
+		data, err := os.ReadFile(securitySeccompPath)
+		if err != nil {
+			panic("cannot read SECURITY_SECCOMP_PROFILE: " + err.Error())
+		}
+		return string(data)
+	}()
+)
+
+// Validate revisa que solo se pidan capacidades permitidas
+func (e *SecurityExceptions) Validate() error {
+	for i, c := range e.CapAdd {
+		c = strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+		if !slices.Contains(grantableCaps, c) {
+			return fmt.Errorf("capability %s cannot be granted", c)
+		}
+		e.CapAdd[i] = c
+	}
+	return nil
+}
+
+// EffectiveSecurity combina el perfil por defecto con las excepciones del contenedor
+func EffectiveSecurity(exceptions *SecurityExceptions) SecurityProfile {
+	profile := SecurityProfile{
+		User:            securityUser,
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		ReadOnlyRootfs:  securityReadOnly,
+		TmpfsSizeMB:     securityTmpfsMB,
+		Seccomp:         "default",
+		Ulimits: map[string]int64{
+			"nofile": securityNofile,
+			"core":   0,
+		},
+	}
+	if seccompProfile != "" {
+		profile.Seccomp = securitySeccompPath
+	}
+
+	if exceptions != nil {
+		profile.CapAdd = exceptions.CapAdd
+		if exceptions.WritableRootfs {
+			profile.ReadOnlyRootfs = false
+		}
+		if exceptions.ImageUser {
+			profile.User = ""
+		}
+	}
+
+	return profile
+}
+
+// apply traduce el perfil a la configuración de Docker
+func (p SecurityProfile) apply(cfg *container.Config, hc *container.HostConfig) {
+	cfg.User = p.User
+	hc.CapDrop = p.CapDrop
+	hc.CapAdd = p.CapAdd
+	hc.ReadonlyRootfs = p.ReadOnlyRootfs
+
+	if p.NoNewPrivileges {
+		hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges:true")
+	}
+	if seccompProfile != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp="+seccompProfile)
+	}
+
+	if p.ReadOnlyRootfs {
+		hc.Tmpfs = map[string]string{
+			"/tmp": fmt.Sprintf("rw,noexec,nosuid,size=%dm", p.TmpfsSizeMB),
+		}
+	}
+
+	for name, limit := range p.Ulimits {
+		hc.Ulimits = append(hc.Ulimits, &container.Ulimit{Name: name, Soft: limit, Hard: limit})
+	}
+}
+
+// PrepareVolume deja la raíz del volumen a nombre del usuario del perfil; los
+// volúmenes nuevos pertenecen a root y un contenedor sin privilegios no podría escribir.
+func (s *store) PrepareVolume(v *Volume, profile SecurityProfile) error {
+	s, end := s.trace("store.PrepareVolume")
+	defer end()
+	if profile.User == "" {
+		return nil
+	}
+
+	ctx := s.baseContext()
+	if err := s.ensureImage(volumeInitImage); err != nil {
+		return err
+	}
+
+	resp, err := s.client.ContainerCreate(ctx, &container.Config{
+		Image: volumeInitImage,
+		Cmd:   []string{"chown", profile.User, "/volume"},
+	}, &container.HostConfig{
+		NetworkMode: "none",
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: v.DockerName,
+			Target: "/volume",
+		}},
+	}, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to prepare volume: %w", err)
+	}
+	defer s.discardContainer(resp.ID)
+
+	if err := s.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to prepare volume: %w", err)
+	}
+
+	statusCh, errCh := s.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to prepare volume: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("failed to prepare volume: chown exited with code %d", status.StatusCode)
+		}
+	}
+
+	dockerLog.InfoContext(ctx, "volumen asignado", "volume", v.Name, "user", profile.User)
+	return nil
+}
+
+func (s *store) UpdateContainerSecurity(userID string, containerName ServiceName, exceptions *SecurityExceptions) error {
+	s, end := s.trace("store.UpdateContainerSecurity")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"security":  exceptions,
+			"updatedAt": time.Now(),
+		},
+	}
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update container security: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+//Nombre de servicio validado. Es la única forma de pasar un nombre recibido del
+//usuario a Docker, a Traefik, a MongoDB o al sistema de archivos.
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// El nombre interno es "svc-<12 hex>-<nombre>" y Redeploy le agrega "-next"; con
+// 40 caracteres sigue siendo una etiqueta DNS válida (63)
+const maxServiceNameLength = 40
+
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Nombres de servicio que chocan con la plataforma o con su enrutamiento. list,
+// graphic, history y last son rutas fijas de /containers/ que taparían a
+// GET /containers/{name}.
+var reservedServiceNames = map[string]bool{
+	"api": true, "traefik": true, "mongodb": true, "admin": true, "auth": true,
+	"list": true, "graphic": true, "history": true, "last": true,
+}
+
+// ServiceName es el nombre de un servicio dentro del usuario, con las reglas de
+// una etiqueta DNS: minúsculas, dígitos y guiones, sin guion al inicio ni al final.
+type ServiceName string
+
+// ParseServiceName valida raw sin normalizarlo: un nombre con mayúsculas o
+// espacios se rechaza en lugar de corregirse.
+func ParseServiceName(raw string) (ServiceName, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("service name is required")
+	case len(raw) > maxServiceNameLength:
+		return "", fmt.Errorf("service name must be at most %d characters", maxServiceNameLength)
+	case !serviceNamePattern.MatchString(raw):
+		return "", fmt.Errorf("service name %q must contain only lowercase letters, digits and hyphens, and start and end with a letter or digit", raw)
+	case reservedServiceNames[raw]:
+		return "", fmt.Errorf("service name %q is reserved", raw)
+	case strings.HasSuffix(raw, "-next"):
+		// Chocaría con el contenedor temporal de un despliegue blue/green
+		return "", fmt.Errorf("service names cannot end in -next")
+	}
+	return ServiceName(raw), nil
+}
+
+func (n ServiceName) String() string {
+	return string(n)
+}
+
+// safeJoin une root y elem y verifica que el resultado quede dentro de root,
+// incluso siguiendo enlaces simbólicos que ya existan.
+func safeJoin(root, elem string) (string, error) {
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(rootAbs); err == nil {
+		rootAbs = resolved
+	}
+
+	target := resolveExisting(filepath.Join(rootAbs, elem))
+
+	rel, err := filepath.Rel(rootAbs, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path %q escapes %s", elem, root)
+	}
+	return target, nil
+}
+
+// resolveExisting resuelve los enlaces del ancestro más profundo que existe,
+// así un directorio enlazado no se cuela aunque el archivo final aún no exista
+func resolveExisting(path string) string {
+	suffix := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, suffix)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path
+		}
+		suffix = filepath.Join(filepath.Base(dir), suffix)
+	}
+}
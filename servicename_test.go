@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseServiceName(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		valid bool
+	}{
+		{"simple", "python-app", true},
+		{"digits", "api2", true},
+		{"single char", "a", true},
+		{"max length", strings.Repeat("a", maxServiceNameLength), true},
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", maxServiceNameLength+1), false},
+		{"dot dot", "..", false},
+		{"parent path", "../etc", false},
+		{"slash", "a/b", false},
+		{"absolute", "/app", false},
+		{"uppercase", "Python-App", false},
+		{"underscore", "python_app", false},
+		{"space", "python app", false},
+		{"leading hyphen", "-app", false},
+		{"trailing hyphen", "app-", false},
+		{"next suffix", "app-next", false},
+		{"only next", "next", true},
+		{"reserved api", "api", false},
+		{"reserved traefik", "traefik", false},
+		{"reserved list route", "list", false},
+		{"reserved graphic route", "graphic", false},
+		{"reserved history route", "history", false},
+		{"reserved last route", "last", false},
+		{"null byte", "app\x00", false},
+		{"newline", "app\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServiceName(tt.raw)
+			if tt.valid && err != nil {
+				t.Fatalf("ParseServiceName(%q) = %v, want valid", tt.raw, err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatalf("ParseServiceName(%q) = %q, want error", tt.raw, got)
+			}
+			if tt.valid && got.String() != tt.raw {
+				t.Fatalf("ParseServiceName(%q) = %q, want it unchanged", tt.raw, got)
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(root, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "data"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		elem  string
+		valid bool
+	}{
+		{"file", "app.py", true},
+		{"subdir", "data/app.py", true},
+		{"cleaned dot dot inside", "data/../app.py", true},
+		{"symlink inside root", "inside/app.py", true},
+		{"root itself", ".", false},
+		{"empty", "", false},
+		{"parent", "..", false},
+		{"dot dot escape", "../etc/passwd", false},
+		{"nested dot dot escape", "data/../../etc/passwd", false},
+		{"symlink escape", "escape", false},
+		{"through symlink escape", "escape/secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := safeJoin(root, tt.elem)
+			if tt.valid && err != nil {
+				t.Fatalf("safeJoin(%q) = %v, want valid", tt.elem, err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatalf("safeJoin(%q) = %q, want error", tt.elem, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+//Consumo de recursos de los contenedores, calculado desde el flujo de stats de
+//Docker igual que lo muestra `docker stats`.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ContainerStats recibe cada muestra de stats del contenedor. Sin stream llama a fn
+// una sola vez (Docker espera un segundo para poder calcular el % de CPU); con
+// stream sigue hasta que se cancela ctx, el contenedor se detiene o fn devuelve error.
+func (s *store) ContainerStats(ctx context.Context, dockerName string, stream bool, fn func(ResourceStats) error) error {
+	resp, err := s.client.ContainerStats(ctx, dockerName, stream)
+	if err != nil {
+		return fmt.Errorf("failed to read stats of %s: %w", dockerName, err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var raw container.StatsResponse
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to decode stats of %s: %w", dockerName, err)
+		}
+		// Un contenedor detenido devuelve muestras vacías
+		if raw.Read.IsZero() {
+			return nil
+		}
+		if err := fn(computeStats(&raw)); err != nil {
+			return err
+		}
+		if !stream {
+			return nil
+		}
+	}
+}
+
+// computeStats convierte una muestra de Docker en porcentajes y totales
+func computeStats(raw *container.StatsResponse) ResourceStats {
+	stats := ResourceStats{
+		MemoryUsage: memoryUsage(raw.MemoryStats),
+		MemoryLimit: raw.MemoryStats.Limit,
+		PIDs:        raw.PidsStats.Current,
+		ReadAt:      raw.Read,
+	}
+
+	onlineCPUs := raw.CPUStats.OnlineCPUs
+	if onlineCPUs == 0 {
+		onlineCPUs = uint32(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * float64(onlineCPUs) * 100
+	}
+
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	for _, n := range raw.Networks {
+		stats.NetworkRx += n.RxBytes
+		stats.NetworkTx += n.TxBytes
+	}
+
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += entry.Value
+		case "write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+
+	return stats
+}
+
+// memoryUsage descuenta la caché de páginas, que el kernel libera cuando hace falta.
+// cgroup v1 la reporta como total_inactive_file y cgroup v2 como inactive_file.
+func memoryUsage(mem container.MemoryStats) uint64 {
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if v, ok := mem.Stats[key]; ok && v < mem.Usage {
+			return mem.Usage - v
+		}
+	}
+	return mem.Usage
+}
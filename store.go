@@ -3,13 +3,11 @@ package main
 //Son todas las funciones que interactúan con la base de datos y Docker
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -17,7 +15,9 @@ import (
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/go-connections/nat"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -29,82 +29,145 @@ type store struct {
 	mongoClient *mongo.Client
 	database    *mongo.Database
 	client      *client.Client
+	ctx         context.Context // ver WithContext
 }
 
 func NewStore(mongoClient *mongo.Client, client *client.Client) *store {
 	return &store{mongoClient: mongoClient, database: mongoClient.Database(mongoDatabaseName), client: client}
 }
 
-var (
-	ctx = context.Background()
-)
-
-func (s *store) NewContainer(containerImage, serviceName string) error {
-	ctx := context.Background()
+// NewContainer crea e inicia el contenedor dockerName del servicio descrito en spec
+func (s *store) NewContainer(containerImage, dockerName string, spec ContainerSpec) error {
+	s, end := s.trace("store.NewContainer")
+	defer end()
+	ctx := s.baseContext()
 
 	if !strings.Contains(containerImage, ":") {
 		containerImage = containerImage + ":latest"
 	}
 
 	// Verificar si el contenedor ya está corriendo
-	isRunning, _ := s.IsContainerRunning(serviceName)
+	isRunning, _ := s.IsContainerRunning(dockerName)
 	if isRunning {
-		return fmt.Errorf("container %s is already running", serviceName)
+		return fmt.Errorf("container %s is already running", dockerName)
+	}
+
+	if err := s.ensureImage(containerImage); err != nil {
+		return err
 	}
 
-	// Verificar si la imagen existe localmente
+	id, err := s.createServiceContainer(ctx, containerImage, dockerName, dockerName, spec)
+	if err != nil {
+		dockerLog.ErrorContext(ctx, "error creando contenedor", "dockerName", dockerName, "error", err)
+		return err
+	}
+
+	// Iniciar contenedor
+	if err := s.client.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		dockerLog.ErrorContext(ctx, "error iniciando contenedor", "dockerName", dockerName, "error", err)
+		return err
+	}
+
+	dockerLog.InfoContext(ctx, "contenedor iniciado", "dockerName", dockerName, "containerId", id)
+	return nil
+}
+
+// ensureImage descarga la imagen de Docker Hub si no existe localmente
+func (s *store) ensureImage(containerImage string) error {
+	ctx := s.baseContext()
 	exists, err := s.ImageExists(containerImage)
 	if err != nil {
-		log.Fatalf("Error checking image existence: %v", err)
 		return err
 	}
 
 	if !exists {
-		log.Printf("Image %s not found, pulling from Docker Hub...", containerImage)
+		dockerLog.InfoContext(ctx, "imagen no encontrada, descargando de Docker Hub", "image", containerImage)
 		if err := s.ImagePull(containerImage); err != nil {
-			log.Printf("Error pulling image: %v", err)
+			dockerLog.ErrorContext(ctx, "error descargando imagen", "image", containerImage, "error", err)
 			return err
 		}
-		log.Printf("Image %s pulled successfully", containerImage)
+		dockerLog.InfoContext(ctx, "imagen descargada", "image", containerImage)
 	}
 
+	return nil
+}
+
+// createServiceContainer crea (sin iniciar) un contenedor del servicio con nombre
+// containerName. Las labels de Traefik dependen solo de dockerName, así que dos
+// contenedores del mismo servicio comparten router y reciben tráfico a la vez.
+func (s *store) createServiceContainer(ctx context.Context, containerImage, dockerName, containerName string, spec ContainerSpec) (string, error) {
 	// Definir puertos expuestos (el microservicio escucha en 8000)
 	portSet := nat.PortSet{
 		"8000/tcp": struct{}{},
 	}
 
+	// Nunca se usa backend-network: ahí están MongoDB y la API
+	if spec.Network == "" || spec.Network == platformNetwork {
+		return "", fmt.Errorf("service %s has no tenant network", spec.Name)
+	}
+	if spec.Name == "" || spec.Tenant == "" {
+		return "", fmt.Errorf("service %s has no public path", dockerName)
+	}
+
+	// Las labels de la imagen se copian al contenedor; una imagen con labels de
+	// Traefik propias podría tomar rutas de otros servicios
+	info, err := s.client.ImageInspect(ctx, containerImage)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", containerImage, err)
+	}
+	if info.Config != nil {
+		if err := checkImageLabels(info.Config.Labels); err != nil {
+			return "", err
+		}
+	}
+
+	hostConfig := &container.HostConfig{
+		NetworkMode: container.NetworkMode(spec.Network), // red del usuario, compartida con Traefik
+		Mounts:      spec.Mounts,
+	}
+	spec.Resources.hostConfig(hostConfig)
+
+	// La ruta pública es /{tenant}/{servicio}; Traefik quita /{tenant} para que el
+	// servicio siga recibiendo /{servicio}
+	path := servicePath(spec.Tenant, spec.Name)
+	stripPrefix := dockerName + "-tenant"
+
 	// Crear contenedor con labels para Traefik
-	resp, err := s.client.ContainerCreate(ctx, &container.Config{
+	config := &container.Config{
 		Image:        containerImage,
 		ExposedPorts: portSet,
-		Env: []string{
-			"MICROSERVICIO_NAME=" + serviceName, // <--- aquí pasamos la variable
-		},
+		Env: append(spec.Env,
+			"MICROSERVICIO_NAME="+spec.Name.String(), // <--- aquí pasamos la variable
+			"MICROSERVICIO_PATH="+path,
+		),
 		Labels: map[string]string{
 			"traefik.enable": "true",
-			"traefik.http.routers." + serviceName + ".rule":                      "PathPrefix(`/" + serviceName + "`)",
-			"traefik.http.services." + serviceName + ".loadbalancer.server.port": "8000",
+			"traefik.http.routers." + dockerName + ".rule":                      "Path(`" + path + "`) || PathPrefix(`" + path + "/`)",
+			"traefik.http.routers." + dockerName + ".middlewares":               stripPrefix,
+			"traefik.http.middlewares." + stripPrefix + ".stripprefix.prefixes": "/" + spec.Tenant,
+			"traefik.http.services." + dockerName + ".loadbalancer.server.port": "8000",
+			"traefik.docker.network":                                            spec.Network,
+			"plataforma.path":                                                   path,
 		},
-	}, &container.HostConfig{
-		NetworkMode: "backend-network", // usa la misma red que Traefik
-	}, nil, nil, serviceName)
-	if err != nil {
-		log.Printf(err.Error())
-		return err
 	}
+	spec.Security.apply(config, hostConfig)
 
-	// Iniciar contenedor
-	if err := s.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		log.Printf(err.Error())
-		return err
+	resp, err := s.client.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			spec.Network: {Aliases: []string{spec.Name.String()}},
+		},
+	}, nil, containerName)
+	if err != nil {
+		return "", err
 	}
 
-	fmt.Printf("Contenedor '%s' iniciado con ID: %s\n", serviceName, resp.ID)
-	return nil
+	return resp.ID, nil
 }
 
 func (s *store) IsContainerRunning(containerName string) (bool, error) {
-	ctx := context.Background()
+	s, end := s.trace("store.IsContainerRunning")
+	defer end()
+	ctx := s.baseContext()
 
 	// Obtener estado del contenedor
 	containerJSON, err := s.client.ContainerInspect(ctx, containerName)
@@ -112,34 +175,38 @@ func (s *store) IsContainerRunning(containerName string) (bool, error) {
 		return false, err
 	}
 
-	fmt.Println("Estado del contenedor:", containerJSON.State.Status)
+	dockerLog.DebugContext(ctx, "estado del contenedor", "container", containerName, "status", containerJSON.State.Status)
 
 	// Retorna si está en estado "running"
 	return containerJSON.State.Running, nil
 }
 
 func (s *store) ImagePull(containerImage string) (err error) {
+	s, end := s.trace("store.ImagePull")
+	defer end()
+	ctx := s.baseContext()
 	ioReader, err := s.client.ImagePull(ctx, containerImage, image.PullOptions{})
 	if err != nil {
-		log.Fatal(err)
-		return err
+		return fmt.Errorf("failed to pull %s: %w", containerImage, err)
 	}
 	defer ioReader.Close()
 
-	_, err = io.Copy(os.Stdout, ioReader)
-	if err != nil {
-		log.Fatalf("Error leyendo salida: %v", err)
-		return err
+	// El progreso de la descarga va a los logs de debug
+	out := &logWriter{ctx: ctx, logger: dockerLog, msg: "pull"}
+	defer out.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(ioReader, out, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to pull %s: %w", containerImage, err)
 	}
 	return nil
 }
 
 func (s *store) ImageExists(imageName string) (bool, error) {
-	ctx := context.Background()
+	s, end := s.trace("store.ImageExists")
+	defer end()
+	ctx := s.baseContext()
 	images, err := s.client.ImageList(ctx, image.ListOptions{})
 	if err != nil {
-		log.Fatalf("Error al listar imágenes: %v", err)
-		return false, err
+		return false, fmt.Errorf("failed to list images: %w", err)
 	}
 
 	for _, img := range images {
@@ -152,81 +219,104 @@ func (s *store) ImageExists(imageName string) (bool, error) {
 	return false, nil
 }
 
-func (s *store) BuildContainerImage(workspaceDir string, imageName string) error {
-	// Crear tar del workspace
-	tarBuf := new(bytes.Buffer)
-	tw := tar.NewWriter(tarBuf)
-
-	err := filepath.Walk(workspaceDir, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			return nil
-		}
-
-		relPath, err := filepath.Rel(workspaceDir, file)
-		if err != nil {
-			return err
-		}
+// BuildContainerImage construye el workspace con todas las etiquetas de tags y
+// devuelve el checksum SHA-256 del contexto enviado a Docker.
+func (s *store) BuildContainerImage(workspaceDir string, tags []string, opts BuildOptions) (string, error) {
+	s, end := s.trace("store.BuildContainerImage")
+	defer end()
+	// Validar el Dockerfile contra la política de builds
+	dockerfile, err := readContextFile(filepath.Join(workspaceDir, "Dockerfile"))
+	if err != nil {
+		return "", fmt.Errorf("error leyendo Dockerfile: %w", err)
+	}
+	if err := buildPolicy.CheckDockerfile(dockerfile, opts.Target); err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidBuildInput, err)
+	}
 
-		f, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+	ctx := s.baseContext()
+	if buildPolicy.MaxBuildTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, buildPolicy.MaxBuildTime)
+		defer cancel()
+	}
 
-		hdr := &tar.Header{
-			Name: relPath,
-			Mode: 0644,
-			Size: fi.Size(),
-		}
-		if err := tw.WriteHeader(hdr); err != nil {
-			return err
-		}
-		if _, err := io.Copy(tw, f); err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("error creando tar: %v", err)
+	// plataforma.commit siempre la pone el build (vacía si no viene de Git), así una
+	// imagen base o el Dockerfile no pueden inventar el commit
+	labels := map[string]string{commitLabel: ""}
+	for k, v := range opts.Labels {
+		labels[k] = v
 	}
-	tw.Close()
 
 	// Construir imagen con Docker
 	buildOptions := build.ImageBuildOptions{
-		Tags:       []string{imageName},
+		Tags:       tags,
 		Dockerfile: "Dockerfile", // Debe existir en workspaceDir
 		Remove:     true,         // Limpiar capas intermedias
-	}
-
-	buildResp, err := s.client.ImageBuild(ctx, bytes.NewReader(tarBuf.Bytes()), buildOptions)
+		BuildArgs:  opts.BuildArgs,
+		Target:     opts.Target,
+		Labels:     labels,
+	}
+
+	// El tar del workspace se genera mientras Docker lo lee, sin cargarlo en memoria;
+	// el checksum se calcula sobre los mismos bytes que se envían
+	pr, pw := io.Pipe()
+	hash := sha256.New()
+	tarErr := make(chan error, 1)
+	go func() {
+		err := writeContextTar(io.MultiWriter(pw, hash), workspaceDir)
+		pw.CloseWithError(err)
+		tarErr <- err
+	}()
+	// Si Docker deja de leer, el cierre desbloquea al escritor
+	defer pr.Close()
+
+	buildResp, err := s.client.ImageBuild(ctx, pr, buildOptions)
 	if err != nil {
-		return fmt.Errorf("error construyendo imagen: %v", err)
+		pr.CloseWithError(err)
+		if terr := <-tarErr; terr != nil {
+			return "", fmt.Errorf("error creando tar: %w", terr)
+		}
+		return "", fmt.Errorf("error construyendo imagen: %v", err)
 	}
 	defer buildResp.Body.Close()
 
-	// Mostrar salida de build
-	if _, err := io.Copy(os.Stdout, buildResp.Body); err != nil {
-		return fmt.Errorf("error leyendo salida build: %v", err)
+	// La salida del build va a los logs de debug; falla si algún paso del Dockerfile falló
+	out := &logWriter{ctx: ctx, logger: buildLog, msg: "build"}
+	defer out.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(buildResp.Body, out, 0, false, nil); err != nil {
+		pr.CloseWithError(err)
+		if terr := <-tarErr; terr != nil {
+			return "", fmt.Errorf("error creando tar: %w", terr)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("el build superó el tiempo máximo de %s", buildPolicy.MaxBuildTime)
+		}
+		return "", fmt.Errorf("error en build: %v", err)
+	}
+
+	pr.Close()
+	if err := <-tarErr; err != nil {
+		return "", fmt.Errorf("error creando tar: %w", err)
 	}
+	checksum := hash.Sum(nil)
 
-	fmt.Println("✅ Imagen construida con nombre:", imageName)
-	return nil
+	buildLog.InfoContext(ctx, "imagen construida", "tags", tags)
+	return hex.EncodeToString(checksum[:]), nil
 }
 
 func (s *store) StopAndRemoveContainer(containerName string) error {
+	s, end := s.trace("store.StopAndRemoveContainer")
+	defer end()
 
 	// Try stopping the container (ignore if it's not running)
-	if err := s.client.ContainerStop(ctx, containerName, container.StopOptions{}); err != nil {
+	if err := s.client.ContainerStop(s.baseContext(), containerName, container.StopOptions{}); err != nil {
 		if !client.IsErrNotFound(err) {
 			return fmt.Errorf("failed to stop container %s: %w", containerName, err)
 		}
 	}
 
 	// Remove the container (force = true ensures cleanup even if stopped fails)
-	if err := s.client.ContainerRemove(ctx, containerName, container.RemoveOptions{Force: true}); err != nil {
+	if err := s.client.ContainerRemove(s.baseContext(), containerName, container.RemoveOptions{Force: true}); err != nil {
 		return fmt.Errorf("failed to remove container %s: %w", containerName, err)
 	}
 
@@ -234,8 +324,10 @@ func (s *store) StopAndRemoveContainer(containerName string) error {
 }
 
 func (s *store) StopContainer(containerName string) error {
+	s, end := s.trace("store.StopContainer")
+	defer end()
 
-	if err := s.client.ContainerStop(ctx, containerName, container.StopOptions{}); err != nil {
+	if err := s.client.ContainerStop(s.baseContext(), containerName, container.StopOptions{}); err != nil {
 		if client.IsErrNotFound(err) {
 			return fmt.Errorf("container %s not found", containerName)
 		}
@@ -246,7 +338,9 @@ func (s *store) StopContainer(containerName string) error {
 }
 
 func (s *store) ListContainers() ([]container.Summary, error) {
-	ctx := context.Background()
+	s, end := s.trace("store.ListContainers")
+	defer end()
+	ctx := s.baseContext()
 
 	// false -> incluye parados y corriendo
 	containers, err := s.client.ContainerList(ctx, container.ListOptions{All: true})
@@ -258,7 +352,9 @@ func (s *store) ListContainers() ([]container.Summary, error) {
 }
 
 func (s *store) StartContainer(containerName string) error {
-	ctx := context.Background()
+	s, end := s.trace("store.StartContainer")
+	defer end()
+	ctx := s.baseContext()
 
 	if err := s.client.ContainerStart(ctx, containerName, container.StartOptions{}); err != nil {
 		if client.IsErrNotFound(err) {
@@ -271,8 +367,10 @@ func (s *store) StartContainer(containerName string) error {
 }
 
 func (s *store) SaveContainer(record ContainerRecord) (primitive.ObjectID, error) {
+	s, end := s.trace("store.SaveContainer")
+	defer end()
 	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	result, err := collection.InsertOne(ctx, record)
@@ -288,9 +386,11 @@ func (s *store) SaveContainer(record ContainerRecord) (primitive.ObjectID, error
 	return insertedID, nil
 }
 
-func (s *store) UpdateContainerStatus(userID string, containerName string, status bool) error {
+func (s *store) UpdateContainerStatus(userID string, containerName ServiceName, status bool) error {
+	s, end := s.trace("store.UpdateContainerStatus")
+	defer end()
 	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{
@@ -318,6 +418,8 @@ func (s *store) UpdateContainerStatus(userID string, containerName string, statu
 }
 
 func (s *store) ContainerExists(name string) (bool, error) {
+	s, end := s.trace("store.ContainerExists")
+	defer end()
 	containers, err := s.ListContainers()
 	if err != nil {
 		return false, err
@@ -335,9 +437,11 @@ func (s *store) ContainerExists(name string) (bool, error) {
 	return false, nil
 }
 
-func (s *store) DeleteContainerDocument(userID, containerName string) error {
+func (s *store) DeleteContainerDocument(userID string, containerName ServiceName) error {
+	s, end := s.trace("store.DeleteContainerDocument")
+	defer end()
 	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{
@@ -357,37 +461,12 @@ func (s *store) DeleteContainerDocument(userID, containerName string) error {
 	return nil
 }
 
-func (s *store) GetContainersByUser(userID string) ([]ContainerRecord, error) {
-	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	filter := bson.M{"userId": userID}
-	cur, err := collection.Find(ctx, filter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to querry containers: %w", err)
-	}
-
-	defer cur.Close(ctx)
-
-	var results []ContainerRecord
-	for cur.Next(ctx) {
-		var rec ContainerRecord
-		if err := cur.Decode(&rec); err != nil {
-			return nil, fmt.Errorf("failed to decode container : %w", err)
-		}
-		results = append(results, rec)
-	}
-	if err := cur.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %w", err)
-	}
-	return results, nil
-}
-
 // Funcion para repetir asyncrono
 func (s *store) GetAllContainers() ([]ContainerRecord, error) {
+	s, end := s.trace("store.GetAllContainers")
+	defer end()
 	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	cur, err := collection.Find(ctx, bson.M{})
@@ -412,8 +491,10 @@ func (s *store) GetAllContainers() ([]ContainerRecord, error) {
 }
 
 func (s *store) SaveUpdate(update ContainerUpdate) (primitive.ObjectID, error) {
+	s, end := s.trace("store.SaveUpdate")
+	defer end()
 	collection := s.database.Collection("history")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	res, err := collection.InsertOne(ctx, update)
@@ -428,36 +509,11 @@ func (s *store) SaveUpdate(update ContainerUpdate) (primitive.ObjectID, error) {
 	return oid, nil
 }
 
-func (s *store) GetHistoryByUser(userID string) ([]ContainerUpdate, error) {
-	collection := s.database.Collection("history")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	filter := bson.M{"userId": userID}
-	cur, err := collection.Find(ctx, filter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to querry containers: %w", err)
-	}
-
-	defer cur.Close(ctx)
-
-	var results []ContainerUpdate
-	for cur.Next(ctx) {
-		var rec ContainerUpdate
-		if err := cur.Decode(&rec); err != nil {
-			return nil, fmt.Errorf("failed to decode container : %w", err)
-		}
-		results = append(results, rec)
-	}
-	if err := cur.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %w", err)
-	}
-	return results, nil
-}
-
 func (s *store) GetAllContainersHistory() ([]ContainerUpdate, error) {
+	s, end := s.trace("store.GetAllContainersHistory")
+	defer end()
 	collection := s.database.Collection("history")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	cur, err := collection.Find(ctx, bson.M{})
@@ -482,8 +538,10 @@ func (s *store) GetAllContainersHistory() ([]ContainerUpdate, error) {
 }
 
 func (s *store) GetLastHistory() (*ContainerUpdate, error) {
+	s, end := s.trace("store.GetLastHistory")
+	defer end()
 	collection := s.database.Collection("history")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
@@ -500,9 +558,11 @@ func (s *store) GetLastHistory() (*ContainerUpdate, error) {
 	return &result, nil
 }
 
-func (s *store) IsOwner(userID, containerName string) (bool, error) {
+func (s *store) IsOwner(userID string, containerName ServiceName) (bool, error) {
+	s, end := s.trace("store.IsOwner")
+	defer end()
 	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{
@@ -524,9 +584,62 @@ func (s *store) IsOwner(userID, containerName string) (bool, error) {
 	return true, nil // Existe → sí es el dueño
 }
 
-func (s *store) UpdateContainerInfo(userID, containerName, newType, newDescription string) error {
+func (s *store) UpdateContainerHealth(userID string, containerName ServiceName, health HealthStatus) error {
+	s, end := s.trace("store.UpdateContainerHealth")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"health": health,
+		},
+	}
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update container health: %w", err)
+	}
+
+	return nil
+}
+
+func (s *store) UpdateContainerProbe(userID string, containerName ServiceName, probe *HealthProbe) error {
+	s, end := s.trace("store.UpdateContainerProbe")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"probe":     probe,
+			"updatedAt": time.Now(),
+		},
+	}
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update container probe: %w", err)
+	}
+
+	return nil
+}
+
+func (s *store) UpdateContainerInfo(userID string, containerName ServiceName, newType, newDescription string) error {
+	s, end := s.trace("store.UpdateContainerInfo")
+	defer end()
 	collection := s.database.Collection("containers")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{
@@ -0,0 +1,158 @@
+package main
+
+//Espacio de nombres por usuario. El nombre del servicio solo es único dentro del
+//usuario; en Docker el contenedor y la imagen usan un nombre interno derivado del
+//ID del usuario, y la ruta pública es /{tenant}/{servicio}.
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// Primer segmento de rutas que ya usa la plataforma en Traefik
+	reservedTenantHandles = map[string]bool{
+		"api": true, "admin": true, "auth": true, "dashboard": true, "metrics": true,
+	}
+	handleInvalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// dockerServiceName es el nombre del contenedor y del repositorio de imágenes del servicio
+func dockerServiceName(userID string, service ServiceName) string {
+	return "svc-" + tenantSlug(userID) + "-" + string(service)
+}
+
+func servicePath(handle string, service ServiceName) string {
+	return "/" + handle + "/" + string(service)
+}
+
+// EnsureTenant devuelve el handle del usuario y lo crea en el primer uso a partir
+// de su email. Si el handle está reservado o tomado se le agrega un sufijo.
+func (s *store) EnsureTenant(userID, email string) (string, error) {
+	s, end := s.trace("store.EnsureTenant")
+	defer end()
+	collection := s.database.Collection("tenants")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var tenant Tenant
+	err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&tenant)
+	if err == nil {
+		return tenant.Handle, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", fmt.Errorf("failed to fetch tenant: %w", err)
+	}
+
+	slug := tenantSlug(userID)
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	handle := strings.Trim(handleInvalidChars.ReplaceAllString(local, "-"), "-")
+	if len(handle) > 32 {
+		handle = handle[:32]
+	}
+	if handle == "" {
+		handle = "u" + slug[:8]
+	}
+
+	// El índice único de handle resuelve las carreras: si otro usuario se lleva el
+	// handle entre medio se prueba el siguiente candidato
+	for _, candidate := range []string{handle, handle + "-" + slug[:6], handle + "-" + slug} {
+		if reservedTenantHandles[candidate] {
+			continue
+		}
+		tenant = Tenant{UserID: userID, Handle: candidate, CreatedAt: time.Now()}
+		_, err := collection.InsertOne(ctx, tenant)
+		if err == nil {
+			tenantLog.InfoContext(ctx, "tenant creado", "handle", candidate)
+			return candidate, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return "", fmt.Errorf("failed to save tenant: %w", err)
+		}
+
+		// Otra petición del mismo usuario pudo crear el tenant primero
+		err = collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&tenant)
+		if err == nil {
+			return tenant.Handle, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return "", fmt.Errorf("failed to fetch tenant: %w", err)
+		}
+	}
+
+	return "", fmt.Errorf("failed to save tenant: no free handle for %q", handle)
+}
+
+// MigrateServiceNames pasa los contenedores creados con el nombre del servicio a
+// su nombre interno. Las labels nuevas se aplican cuando MigrateServiceContainers
+// reemplaza cada contenedor.
+func MigrateServiceNames(s *store) {
+	s, span := startSpan(s, "migrate.serviceNames")
+	defer span.End()
+
+	records, err := s.GetAllContainers()
+	if err != nil {
+		migrateLog.ErrorContext(s.baseContext(), "error leyendo containers", "error", err)
+		return
+	}
+
+	for _, rec := range records {
+		if rec.DockerName != "" {
+			continue
+		}
+
+		handle, err := s.EnsureTenant(rec.UserID, "")
+		if err != nil {
+			migrateLog.ErrorContext(s.baseContext(), "error creando tenant", "containerName", rec.ContainerName, "error", err)
+			continue
+		}
+
+		dockerName := dockerServiceName(rec.UserID, rec.ContainerName)
+		// Si el contenedor ya no existe basta con actualizar el registro
+		err = s.client.ContainerRename(s.baseContext(), rec.ContainerName.String(), dockerName)
+		if err != nil && !client.IsErrNotFound(err) {
+			migrateLog.ErrorContext(s.baseContext(), "no se pudo renombrar el contenedor", "containerName", rec.ContainerName, "error", err)
+			continue
+		}
+
+		if err := s.UpdateContainerNaming(rec.UserID, rec.ContainerName, dockerName, servicePath(handle, rec.ContainerName)); err != nil {
+			migrateLog.ErrorContext(s.baseContext(), "error actualizando el registro", "containerName", rec.ContainerName, "error", err)
+			continue
+		}
+		migrateLog.InfoContext(s.baseContext(), "contenedor renombrado", "containerName", rec.ContainerName, "dockerName", dockerName)
+	}
+}
+
+func (s *store) UpdateContainerNaming(userID string, containerName ServiceName, dockerName, path string) error {
+	s, end := s.trace("store.UpdateContainerNaming")
+	defer end()
+	collection := s.database.Collection("containers")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":        userID,
+		"containerName": containerName,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"dockerName": dockerName,
+			"path":       path,
+			"updatedAt":  time.Now(),
+		},
+	}
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update container naming: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+//Trazas de OpenTelemetry. Cada petición HTTP abre un span que se propaga a los
+//métodos de store, a las llamadas a Docker y MongoDB, a la verificación de tokens
+//y, como cabecera traceparent, a las sondas HTTP de los microservicios.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// none (por defecto), otlp, stdout o file. otlp se configura con las variables
+	// estándar OTEL_EXPORTER_OTLP_*, y el muestreo con OTEL_TRACES_SAMPLER
+	tracesExporter = GetEnv("OTEL_TRACES_EXPORTER", "none")
+	tracesFile     = GetEnv("OTEL_TRACES_FILE", "./traces.jsonl")
+	serviceName    = GetEnv("OTEL_SERVICE_NAME", "plataforma-api")
+)
+
+var tracer = otel.Tracer("plataformaMicroservicios")
+
+// tracingClient se usa en las llamadas HTTP salientes para crear un span por
+// llamada e inyectar la cabecera traceparent
+var tracingClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// traceHeaderArgs devuelve las cabeceras de propagación del span de ctx como
+// argumentos --header de wget, para las sondas que corren en el sidecar
+func traceHeaderArgs(ctx context.Context) []string {
+	carrier := propagation.HeaderCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	var args []string
+	for _, key := range carrier.Keys() {
+		args = append(args, "--header", key+": "+carrier.Get(key))
+	}
+	return args
+}
+
+// SetupTracing configura el exportador según OTEL_TRACES_EXPORTER y devuelve la
+// función que vacía los spans pendientes al terminar
+func SetupTracing() (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch tracesExporter {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "file":
+		var f io.Writer
+		f, err = os.OpenFile(tracesFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q (use none, otlp, stdout or file)", tracesExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", tracesExporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("exportando trazas", "exporter", tracesExporter)
+
+	return provider.Shutdown, nil
+}
+
+// traceHTTP abre el span de cada petición. Al terminar, otelhttp lo renombra con el
+// patrón de la ruta que resolvió el mux (por ejemplo "GET /containers/{name}")
+func traceHTTP(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http",
+		otelhttp.WithFilter(func(r *http.Request) bool { return r.URL.Path != "/metrics" }),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			if r.Pattern != "" {
+				return r.Pattern
+			}
+			return r.Method + " " + operation
+		}),
+	)
+}
+
+// WithContext devuelve una copia del store cuyas operaciones cuelgan del span de
+// ctx. Los handlers la usan con el contexto de la petición; solo se hereda la
+// traza y no la cancelación, para que un cliente que corta no deje un despliegue
+// a medias.
+func (s *store) WithContext(ctx context.Context) *store {
+	c := *s
+	c.ctx = context.WithoutCancel(ctx)
+	return &c
+}
+
+// baseContext es el contexto del que derivan las llamadas a Docker y MongoDB
+func (s *store) baseContext() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+// trace abre un span hijo del contexto del store y devuelve una copia del store
+// que cuelga de él; se usa como
+//
+//	s, end := s.trace("store.GetContainer")
+//	defer end()
+func (s *store) trace(name string, attrs ...attribute.KeyValue) (*store, func()) {
+	ctx, span := tracer.Start(s.baseContext(), name, trace.WithAttributes(attrs...))
+	return s.WithContext(ctx), func() { span.End() }
+}
+
+// startSpan abre un span raíz para el trabajo en segundo plano (reconciliador,
+// monitor de salud, GC...) y devuelve el store que cuelga de él
+func startSpan(s *store, name string) (*store, trace.Span) {
+	ctx, span := tracer.Start(context.Background(), name)
+	return s.WithContext(ctx), span
+}
+
+// mongoSpans guarda los spans abiertos de los comandos en curso, por RequestID
+var mongoSpans sync.Map
+
+// mongoMonitor cuenta los comandos de MongoDB que fallan y abre un span por comando
+func mongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			_, span := tracer.Start(ctx, "mongo."+evt.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemMongoDB,
+					semconv.DBNamespace(evt.DatabaseName),
+					semconv.DBOperationName(evt.CommandName),
+				),
+			)
+			mongoSpans.Store(evt.RequestID, span)
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			if span, ok := mongoSpans.LoadAndDelete(evt.RequestID); ok {
+				span.(trace.Span).End()
+			}
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			mongoErrors.WithLabelValues(evt.CommandName).Inc()
+			if span, ok := mongoSpans.LoadAndDelete(evt.RequestID); ok {
+				span.(trace.Span).SetStatus(codes.Error, evt.Failure)
+				span.(trace.Span).End()
+			}
+		},
+	}
+}
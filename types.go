@@ -1,17 +1,35 @@
 package main
 
-import "time"
+import (
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type contenedor struct {
-	Image       string `json:"image" bson:"image" validate:"required"`
-	Type        string `bson:"type" json:"type" validate:"required"`
-	Description string `bson:"description" json:"description" validate:"required"`
+	Image       string          `json:"image" bson:"image" validate:"required"`
+	Name        string          `json:"name,omitempty" bson:"name,omitempty"` // por defecto, la imagen
+	Type        string          `bson:"type" json:"type" validate:"required"`
+	Description string          `bson:"description" json:"description" validate:"required"`
+	Probe       *HealthProbe    `bson:"probe,omitempty" json:"probe,omitempty"`
+	Resources   *ResourceLimits `bson:"resources,omitempty" json:"resources,omitempty"`
 }
 
 type contenedorCreated struct {
 	Image string `json:"image" bson:"image" validate:"required"`
 }
 
+type gitBuild struct {
+	Name      string            `json:"name" validate:"required"`
+	Repo      string            `json:"repo" validate:"required"`
+	Ref       string            `json:"ref"`
+	Subdir    string            `json:"subdir"`
+	BuildArgs map[string]string `json:"buildArgs"`
+	Target    string            `json:"target"`
+}
+
 type registerUser struct {
 	Email    string `json:"email" bson:"email" validate:"required,email"`
 	Password string `json:"password" bson:"password" validate:"required"`
@@ -23,6 +41,15 @@ type loginUser struct {
 	Password string `json:"password" bson:"password" validate:"required"`
 }
 
+// LogValue deja fuera la contraseña si el payload llega a un log
+func (u registerUser) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("email", u.Email), slog.String("name", u.Name))
+}
+
+func (u loginUser) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("email", u.Email))
+}
+
 type loginResponse struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken"`
@@ -40,18 +67,347 @@ type verifyTokenResponse struct {
 }
 
 type ContainerRecord struct {
-	UserID        string    `bson:"userId" json:"userId"`
-	ContainerName string    `bson:"containerName" json:"containerName"`
-	Status        bool      `bson:"status" json:"status"`
-	Description   string    `bson:"description" json:"description" validate:"required"`
-	CreatedAt     time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt     time.Time `bson:"updatedAt" json:"updatedAt"`
-	Type          string    `bson:"type" json:"type"`
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID        string              `bson:"userId" json:"userId"`
+	ContainerName ServiceName         `bson:"containerName" json:"containerName"` // único por usuario
+	DockerName    string              `bson:"dockerName,omitempty" json:"-"`
+	Path          string              `bson:"path,omitempty" json:"path,omitempty"`
+	Status        bool                `bson:"status" json:"status"`
+	Description   string              `bson:"description" json:"description" validate:"required"`
+	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time           `bson:"updatedAt" json:"updatedAt"`
+	Type          string              `bson:"type" json:"type"`
+	CommitSHA     string              `bson:"commitSha,omitempty" json:"commitSha,omitempty"`
+	Release       int                 `bson:"release,omitempty" json:"release,omitempty"`
+	Probe         *HealthProbe        `bson:"probe,omitempty" json:"probe,omitempty"`
+	Health        *HealthStatus       `bson:"health,omitempty" json:"health,omitempty"`
+	Resources     ResourceLimits      `bson:"resources" json:"resources"`
+	Security      *SecurityExceptions `bson:"security,omitempty" json:"security,omitempty"`
 }
 
+type Release struct {
+	UserID         string      `bson:"userId" json:"userId"`
+	ContainerName  ServiceName `bson:"containerName" json:"containerName"`
+	Version        int         `bson:"version" json:"version"`
+	Image          string      `bson:"image" json:"image"`
+	Digest         string      `bson:"digest" json:"digest"`
+	SourceChecksum string      `bson:"sourceChecksum" json:"sourceChecksum"`
+	Runtime        string      `bson:"runtime" json:"runtime"`
+	CommitSHA      string      `bson:"commitSha,omitempty" json:"commitSha,omitempty"`
+	SourceArchive  string      `bson:"sourceArchive,omitempty" json:"-"` // tar.gz del contexto del build
+	BuildMillis    int64       `bson:"buildMs" json:"buildMs"`
+	CreatedAt      time.Time   `bson:"createdAt" json:"createdAt"`
+}
+
+type rollbackRequest struct {
+	Version int `json:"version" validate:"required"`
+}
+
+// ContainerUpdate es una entrada del historial de un servicio. Event es uno de los
+// History* de history.go.
 type ContainerUpdate struct {
-	UserID        string    `bson:"userId" json:"userId"`
-	ContainerName string    `bson:"containerName" json:"containerName"`
-	Status        bool      `bson:"status" json:"status"`
-	CreatedAt     time.Time `bson:"createdAt" json:"createdAt"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        string             `bson:"userId" json:"userId"`
+	ContainerName ServiceName        `bson:"containerName" json:"containerName"`
+	Event         string             `bson:"event" json:"event"`
+	Actor         string             `bson:"actor,omitempty" json:"actor,omitempty"` // ID del usuario o componente de la plataforma
+	Reason        string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	ExitCode      *int               `bson:"exitCode,omitempty" json:"exitCode,omitempty"`
+	Release       int                `bson:"release,omitempty" json:"release,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type HealthProbe struct {
+	Type            string `json:"type" bson:"type"`                     // http, tcp, docker o running
+	Path            string `json:"path,omitempty" bson:"path,omitempty"` // solo http
+	TimeoutSeconds  int    `json:"timeoutSeconds,omitempty" bson:"timeoutSeconds,omitempty"`
+	IntervalSeconds int    `json:"intervalSeconds,omitempty" bson:"intervalSeconds,omitempty"`
+	Retries         int    `json:"retries,omitempty" bson:"retries,omitempty"` // fallos seguidos para marcar unhealthy
+	// Segundos desde el arranque en los que los fallos no cuentan
+	StartPeriodSeconds int `json:"startPeriodSeconds,omitempty" bson:"startPeriodSeconds,omitempty"`
+}
+
+type ProbeResult struct {
+	Healthy    bool      `json:"healthy" bson:"healthy"`
+	Type       string    `json:"type" bson:"type"`
+	Message    string    `json:"message,omitempty" bson:"message,omitempty"`
+	Attempts   int       `json:"attempts" bson:"attempts"`
+	DurationMs int64     `json:"durationMs" bson:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt" bson:"checkedAt"`
+}
+
+type HealthStatus struct {
+	Status              string    `json:"status" bson:"status"` // healthy, unhealthy o unknown
+	Message             string    `json:"message,omitempty" bson:"message,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures" bson:"consecutiveFailures"`
+	CheckedAt           time.Time `json:"checkedAt" bson:"checkedAt"`
+}
+
+type ResourceLimits struct {
+	CPUs          float64 `json:"cpus,omitempty" bson:"cpus,omitempty"`
+	MemoryMB      int64   `json:"memoryMb,omitempty" bson:"memoryMb,omitempty"`
+	PidsLimit     int64   `json:"pidsLimit,omitempty" bson:"pidsLimit,omitempty"`
+	RestartPolicy string  `json:"restartPolicy,omitempty" bson:"restartPolicy,omitempty"` // no, on-failure, unless-stopped o always
+	MaxRestarts   int     `json:"maxRestarts,omitempty" bson:"maxRestarts,omitempty"`     // solo on-failure
+}
+
+// ContainerSpec es la configuración con la que se crea el contenedor de un servicio
+type ContainerSpec struct {
+	Name      ServiceName
+	Tenant    string // handle del usuario, primer segmento de la ruta
+	Resources ResourceLimits
+	Env       []string // "CLAVE=valor", ya descifrado
+	Mounts    []mount.Mount
+	Network   string // red del usuario a la que se conecta
+	Security  SecurityProfile
+}
+
+// SecurityProfile es el perfil efectivo con el que corre un contenedor
+type SecurityProfile struct {
+	User            string           `json:"user"` // vacío: el usuario de la imagen
+	CapDrop         []string         `json:"capDrop"`
+	CapAdd          []string         `json:"capAdd,omitempty"`
+	NoNewPrivileges bool             `json:"noNewPrivileges"`
+	ReadOnlyRootfs  bool             `json:"readOnlyRootfs"`
+	TmpfsSizeMB     int64            `json:"tmpfsMb"`
+	Seccomp         string           `json:"seccomp"`
+	Ulimits         map[string]int64 `json:"ulimits"`
+}
+
+// SecurityExceptions son las relajaciones del perfil otorgadas por un administrador
+type SecurityExceptions struct {
+	CapAdd         []string  `json:"capAdd,omitempty" bson:"capAdd,omitempty"`
+	WritableRootfs bool      `json:"writableRootfs" bson:"writableRootfs"`
+	ImageUser      bool      `json:"imageUser" bson:"imageUser"` // corre con el usuario de la imagen
+	GrantedBy      string    `json:"grantedBy" bson:"grantedBy"`
+	GrantedAt      time.Time `json:"grantedAt" bson:"grantedAt"`
+}
+
+// Tenant asocia al usuario con su handle, el primer segmento de sus rutas
+type Tenant struct {
+	UserID    string    `bson:"userId" json:"-"`
+	Handle    string    `bson:"handle" json:"handle"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// NetworkPolicy define la red de los servicios de un usuario. Egress permite salir
+// a internet; ServiceToService pone todos sus servicios en una misma red, si no
+// cada servicio queda en la suya.
+type NetworkPolicy struct {
+	UserID           string    `bson:"userId" json:"-"`
+	Egress           bool      `bson:"egress" json:"egress"`
+	ServiceToService bool      `bson:"serviceToService" json:"serviceToService"`
+	UpdatedAt        time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ServiceConfig guarda las variables de entorno de un servicio. Secrets contiene
+// los valores cifrados y DataKey la llave de datos cifrada con la llave maestra.
+type ServiceConfig struct {
+	UserID        string            `bson:"userId" json:"-"`
+	ContainerName ServiceName       `bson:"containerName" json:"containerName"`
+	Vars          map[string]string `bson:"vars" json:"vars"`
+	Secrets       map[string]string `bson:"secrets" json:"-"`
+	DataKey       string            `bson:"dataKey,omitempty" json:"-"`
+	UpdatedAt     time.Time         `bson:"updatedAt" json:"updatedAt"`
+}
+
+type envValueRequest struct {
+	Value string `json:"value"`
+}
+
+type Plan struct {
+	UserID           string         `json:"-" bson:"userId,omitempty"`
+	Name             string         `json:"name" bson:"name"`
+	Defaults         ResourceLimits `json:"defaults" bson:"defaults"`
+	Max              ResourceLimits `json:"max" bson:"max"`
+	MaxContainers    int            `json:"maxContainers" bson:"maxContainers"`
+	MaxTotalMemoryMB int64          `json:"maxTotalMemoryMb" bson:"maxTotalMemoryMb"`
+	MaxBuildsPerDay  int            `json:"maxBuildsPerDay" bson:"maxBuildsPerDay"`
+	MaxVolumes       int            `json:"maxVolumes" bson:"maxVolumes"`
+	MaxVolumeMB      int64          `json:"maxVolumeMb" bson:"maxVolumeMb"` // suma de todos los volúmenes
+}
+
+type Usage struct {
+	Containers  int     `json:"containers"`
+	MemoryMB    int64   `json:"memoryMb"`
+	CPUs        float64 `json:"cpus"`
+	BuildsToday int     `json:"buildsToday"`
+	Volumes     int     `json:"volumes"`
+	VolumeMB    int64   `json:"volumeMb"`
+}
+
+// Volume es un volumen persistente de un usuario. DockerName es el nombre real en
+// Docker, distinto por usuario aunque dos usuarios usen el mismo Name.
+type Volume struct {
+	UserID     string            `bson:"userId" json:"-"`
+	Name       string            `bson:"name" json:"name"`
+	DockerName string            `bson:"dockerName" json:"-"`
+	SizeMB     int64             `bson:"sizeMb" json:"sizeMb"`
+	Attachment *VolumeAttachment `bson:"attachment,omitempty" json:"attachment,omitempty"`
+	CreatedAt  time.Time         `bson:"createdAt" json:"createdAt"`
+}
+
+type VolumeAttachment struct {
+	ContainerName ServiceName `bson:"containerName" json:"containerName"`
+	MountPath     string      `bson:"mountPath" json:"mountPath"`
+	ReadOnly      bool        `bson:"readOnly" json:"readOnly"`
+}
+
+type networkPolicyRequest struct {
+	Egress           *bool `json:"egress"`
+	ServiceToService *bool `json:"serviceToService"`
+}
+
+type volumeRequest struct {
+	Name   string `json:"name" validate:"required"`
+	SizeMB int64  `json:"sizeMb"`
+}
+
+type attachVolumeRequest struct {
+	Volume    string `json:"volume" validate:"required"`
+	MountPath string `json:"mountPath" validate:"required"`
+	ReadOnly  bool   `json:"readOnly"`
+}
+
+// GCReport es el resultado de una pasada del GC de imágenes; con DryRun solo
+// describe lo que se eliminaría
+type GCReport struct {
+	DryRun         bool         `json:"dryRun"`
+	Releases       []string     `json:"releases"` // releases que exceden la retención
+	OrphanImages   []GCImage    `json:"orphanImages"`
+	DanglingImages []GCImage    `json:"danglingImages"`
+	BuildCache     GCBuildCache `json:"buildCache"`
+	ReclaimableMB  int64        `json:"reclaimableMb"` // estimado, las capas compartidas cuentan más de una vez
+	Errors         []string     `json:"errors,omitempty"`
+	StartedAt      time.Time    `json:"startedAt"`
+	FinishedAt     time.Time    `json:"finishedAt"`
+}
+
+type GCImage struct {
+	ID     string   `json:"id"`
+	Tags   []string `json:"tags,omitempty"`
+	SizeMB int64    `json:"sizeMb"`
+}
+
+type GCBuildCache struct {
+	SizeMB      int64 `json:"sizeMb"`
+	LimitMB     int64 `json:"limitMb"`
+	Prune       bool  `json:"prune"`
+	ReclaimedMB int64 `json:"reclaimedMb,omitempty"`
+}
+
+// ResourceStats es una muestra del consumo de un contenedor. Los contadores de red
+// y disco son acumulados desde que arrancó.
+type ResourceStats struct {
+	CPUPercent    float64   `json:"cpuPercent"` // 100 = un núcleo completo
+	MemoryUsage   uint64    `json:"memoryBytes"`
+	MemoryLimit   uint64    `json:"memoryLimitBytes"`
+	MemoryPercent float64   `json:"memoryPercent"`
+	NetworkRx     uint64    `json:"networkRxBytes"`
+	NetworkTx     uint64    `json:"networkTxBytes"`
+	BlockRead     uint64    `json:"blockReadBytes"`
+	BlockWrite    uint64    `json:"blockWriteBytes"`
+	PIDs          uint64    `json:"pids"`
+	ReadAt        time.Time `json:"readAt"`
+}
+
+// MetricSample es una muestra guardada en la colección time-series "metrics"
+type MetricSample struct {
+	Time        time.Time  `bson:"ts"`
+	Meta        MetricMeta `bson:"meta"`
+	CPUPercent  float64    `bson:"cpuPercent"`
+	MemoryBytes int64      `bson:"memoryBytes"`
+	NetworkRx   int64      `bson:"networkRx"` // acumulado desde que arrancó el contenedor
+	NetworkTx   int64      `bson:"networkTx"`
+}
+
+type MetricMeta struct {
+	UserID        string      `bson:"userId"`
+	ContainerName ServiceName `bson:"containerName"`
+}
+
+// AlertRule avisa por webhook cuando pasa algo en los servicios del usuario. Sin
+// ContainerName aplica a todos sus servicios.
+type AlertRule struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID          string             `bson:"userId" json:"-"`
+	ContainerName   ServiceName        `bson:"containerName,omitempty" json:"containerName,omitempty"`
+	Type            string             `bson:"type" json:"type"`                               // crash, crash_loop, unhealthy, memory o build_failed
+	Threshold       float64            `bson:"threshold,omitempty" json:"threshold,omitempty"` // memory: % del límite; crash_loop: caídas
+	WindowMinutes   int                `bson:"windowMinutes,omitempty" json:"windowMinutes,omitempty"`
+	CooldownMinutes int                `bson:"cooldownMinutes" json:"cooldownMinutes"`
+	URL             string             `bson:"url" json:"url"`
+	Format          string             `bson:"format" json:"format"` // json o slack
+	Secret          string             `bson:"secret" json:"secret,omitempty"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type alertRuleRequest struct {
+	ContainerName   string  `json:"containerName"`
+	Type            string  `json:"type" validate:"required,oneof=crash crash_loop unhealthy memory build_failed"`
+	Threshold       float64 `json:"threshold" validate:"gte=0"`
+	WindowMinutes   int     `json:"windowMinutes" validate:"gte=0"`
+	CooldownMinutes *int    `json:"cooldownMinutes" validate:"omitempty,gte=0"`
+	URL             string  `json:"url" validate:"required"`
+	Format          string  `json:"format" validate:"omitempty,oneof=json slack"`
+}
+
+// Alert es lo que se envía cuando se dispara una regla
+type Alert struct {
+	RuleID        string         `json:"ruleId"`
+	Type          string         `json:"type"`
+	ContainerName ServiceName    `json:"containerName"`
+	Message       string         `json:"message"`
+	Details       map[string]any `json:"details,omitempty"`
+	FiredAt       time.Time      `json:"firedAt"`
+}
+
+// WebhookDelivery es un envío pendiente o terminado de la cola de webhooks. Se
+// reintenta con backoff exponencial hasta entregarse o agotar los intentos.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	UserID         string             `bson:"userId" json:"-"`
+	Source         string             `bson:"source" json:"source"` // alert o webhook
+	SourceID       string             `bson:"sourceId" json:"sourceId"`
+	Event          string             `bson:"event" json:"event"`
+	URL            string             `bson:"url" json:"url"`
+	Secret         string             `bson:"secret" json:"-"`
+	Payload        string             `bson:"payload" json:"payload"`
+	Status         string             `bson:"status" json:"status"` // pending, delivered, failed o canceled
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time          `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LastStatusCode int                `bson:"lastStatusCode,omitempty" json:"lastStatusCode,omitempty"`
+	LastError      string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	DeliveredAt    *time.Time         `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+}
+
+// WebhookSubscription recibe los eventos de la plataforma del usuario. Sin
+// eventos recibe todos.
+type WebhookSubscription struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      string             `bson:"userId" json:"-"`
+	URL         string             `bson:"url" json:"url"`
+	Events      []string           `bson:"events" json:"events"`
+	Format      string             `bson:"format" json:"format"` // json o slack
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Secret      string             `bson:"secret" json:"secret,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type webhookSubscriptionRequest struct {
+	URL         string   `json:"url" validate:"required"`
+	Events      []string `json:"events"`
+	Format      string   `json:"format" validate:"omitempty,oneof=json slack"`
+	Description string   `json:"description" validate:"max=200"`
+}
+
+// ServiceEvent es lo que se envía con cada evento de la plataforma
+type ServiceEvent struct {
+	ContainerName ServiceName `json:"containerName"`
+	Release       int         `json:"release,omitempty"`
+	Image         string      `json:"image,omitempty"`
+	Status        string      `json:"status,omitempty"`
+	Actor         string      `json:"actor"` // ID del usuario que hizo la operación
+	At            time.Time   `json:"at"`
 }
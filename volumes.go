@@ -0,0 +1,291 @@
+package main
+
+//Volúmenes persistentes por usuario. Sobreviven al reemplazo del contenedor
+//porque se montan por nombre en cada contenedor nuevo del servicio.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errInvalidVolume    = errors.New("invalid volume")
+	errVolumeSize       = errors.New("volumes are disabled: VOLUME_DRIVER does not enforce VOLUME_SIZE_OPT")
+	volumeDriver        = GetEnv("VOLUME_DRIVER", "local")
+	volumeSizeOpt       = GetEnv("VOLUME_SIZE_OPT", "") // opción del driver para el tamaño, p. ej. "size"
+	volumeDefaultSizeMB = int64(GetEnvInt("VOLUME_DEFAULT_SIZE_MB", 256))
+	volumeNamePattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+	// Lo decide CheckVolumeDriver al arrancar
+	volumesEnabled bool
+)
+
+// CheckVolumeDriver prueba al arrancar que VOLUME_DRIVER respete VOLUME_SIZE_OPT
+// creando y borrando un volumen de 1 MB. Si no, los volúmenes quedan deshabilitados
+// y se avisa en el log en lugar de fallar recién al crear el primero.
+func (s *store) CheckVolumeDriver() {
+	s, end := s.trace("store.CheckVolumeDriver")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 30*time.Second)
+	defer cancel()
+
+	if volumeSizeOpt == "" {
+		dockerLog.ErrorContext(ctx, "volúmenes deshabilitados: VOLUME_SIZE_OPT está vacío; configure VOLUME_DRIVER y VOLUME_SIZE_OPT con un driver que limite el tamaño", "driver", volumeDriver)
+		return
+	}
+
+	name := "plataforma-volume-check"
+	created, err := s.client.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       name,
+		Driver:     volumeDriver,
+		DriverOpts: map[string]string{volumeSizeOpt: "1m"},
+	})
+	if err != nil {
+		dockerLog.ErrorContext(ctx, "volúmenes deshabilitados: el driver rechazó la opción de tamaño", "driver", volumeDriver, "option", volumeSizeOpt, "error", err)
+		return
+	}
+	if err := s.client.VolumeRemove(ctx, name, true); err != nil && !client.IsErrNotFound(err) {
+		dockerLog.WarnContext(ctx, "error eliminando volumen de prueba", "volume", name, "error", err)
+	}
+	if created.Options[volumeSizeOpt] != "1m" {
+		dockerLog.ErrorContext(ctx, "volúmenes deshabilitados: el driver descarta la opción de tamaño", "driver", volumeDriver, "option", volumeSizeOpt)
+		return
+	}
+
+	volumesEnabled = true
+	dockerLog.InfoContext(ctx, "volúmenes habilitados", "driver", volumeDriver, "option", volumeSizeOpt)
+}
+
+// Rutas donde no se permite montar un volumen
+var forbiddenMountPaths = []string{"/", "/app", "/bin", "/dev", "/etc", "/lib", "/proc", "/sbin", "/sys", "/usr"}
+
+func validateMountPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("mount path must be absolute")
+	}
+	clean := path.Clean(p)
+	for _, forbidden := range forbiddenMountPaths {
+		if clean == forbidden || (forbidden != "/" && strings.HasPrefix(clean, forbidden+"/")) {
+			return "", fmt.Errorf("mount path %s is not allowed", clean)
+		}
+	}
+	return clean, nil
+}
+
+// dockerVolumeName incluye el usuario para que los nombres no choquen entre usuarios
+func dockerVolumeName(userID, name string) string {
+	return "plataforma-" + tenantSlug(userID) + "-" + name
+}
+
+func (s *store) GetVolumes(userID string) ([]Volume, error) {
+	s, end := s.trace("store.GetVolumes")
+	defer end()
+	collection := s.database.Collection("volumes")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch volumes: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	volumes := []Volume{}
+	if err := cur.All(ctx, &volumes); err != nil {
+		return nil, fmt.Errorf("failed to decode volumes: %w", err)
+	}
+
+	return volumes, nil
+}
+
+// GetVolume devuelve nil si el volumen no existe o es de otro usuario
+func (s *store) GetVolume(userID, name string) (*Volume, error) {
+	s, end := s.trace("store.GetVolume")
+	defer end()
+	collection := s.database.Collection("volumes")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var v Volume
+	err := collection.FindOne(ctx, bson.M{"userId": userID, "name": name}).Decode(&v)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch volume: %w", err)
+	}
+
+	return &v, nil
+}
+
+// CreateVolume crea el volumen en Docker y lo registra, respetando las cuotas del plan
+func (s *store) CreateVolume(userID, name string, sizeMB int64) (*Volume, error) {
+	s, end := s.trace("store.CreateVolume")
+	defer end()
+	if !volumeNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: volume name must match %s", errInvalidVolume, volumeNamePattern)
+	}
+	if sizeMB <= 0 {
+		sizeMB = volumeDefaultSizeMB
+	}
+	// Sin una opción de tamaño el driver no limita nada y la cuota sería ficticia
+	if !volumesEnabled {
+		return nil, errVolumeSize
+	}
+
+	existing, err := s.GetVolume(userID, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("%w: volume %s already exists", errInvalidVolume, name)
+	}
+
+	plan, err := s.GetPlan(userID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.GetUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.MaxVolumes > 0 && usage.Volumes+1 > plan.MaxVolumes {
+		return nil, fmt.Errorf("%w: plan allows %d volumes", errQuotaExceeded, plan.MaxVolumes)
+	}
+	if plan.MaxVolumeMB > 0 && usage.VolumeMB+sizeMB > plan.MaxVolumeMB {
+		return nil, fmt.Errorf("%w: plan allows %d MB of volumes in total, %d MB in use", errQuotaExceeded, plan.MaxVolumeMB, usage.VolumeMB)
+	}
+
+	v := Volume{
+		UserID:     userID,
+		Name:       name,
+		DockerName: dockerVolumeName(userID, name),
+		SizeMB:     sizeMB,
+		CreatedAt:  time.Now(),
+	}
+
+	size := fmt.Sprintf("%dm", sizeMB)
+	opts := volume.CreateOptions{
+		Name:   v.DockerName,
+		Driver: volumeDriver,
+		Labels: map[string]string{
+			"plataforma.user":   userID,
+			"plataforma.volume": name,
+		},
+		DriverOpts: map[string]string{volumeSizeOpt: size},
+	}
+
+	ctx, cancel := context.WithTimeout(s.baseContext(), 30*time.Second)
+	defer cancel()
+
+	created, err := s.client.VolumeCreate(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
+	// Algunos drivers descartan las opciones que no entienden en lugar de fallar
+	if created.Options[volumeSizeOpt] != size {
+		if err := s.client.VolumeRemove(ctx, v.DockerName, true); err != nil && !client.IsErrNotFound(err) {
+			dockerLog.ErrorContext(ctx, "error eliminando volumen sin límite", "volume", v.DockerName, "error", err)
+		}
+		return nil, fmt.Errorf("%w: driver %s ignored option %s", errVolumeSize, volumeDriver, volumeSizeOpt)
+	}
+
+	if _, err := s.database.Collection("volumes").InsertOne(ctx, v); err != nil {
+		return nil, fmt.Errorf("failed to save volume: %w", err)
+	}
+
+	return &v, nil
+}
+
+// DeleteVolume elimina el volumen y sus datos; debe estar desconectado
+func (s *store) DeleteVolume(v *Volume) error {
+	s, end := s.trace("store.DeleteVolume")
+	defer end()
+	if v.Attachment != nil {
+		return fmt.Errorf("volume %s is attached to %s", v.Name, v.Attachment.ContainerName)
+	}
+
+	ctx, cancel := context.WithTimeout(s.baseContext(), 30*time.Second)
+	defer cancel()
+
+	if err := s.client.VolumeRemove(ctx, v.DockerName, false); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove volume: %w", err)
+	}
+
+	if _, err := s.database.Collection("volumes").DeleteOne(ctx, bson.M{"userId": v.UserID, "name": v.Name}); err != nil {
+		return fmt.Errorf("failed to delete volume: %w", err)
+	}
+
+	return nil
+}
+
+// SetVolumeAttachment conecta (attachment != nil) o desconecta el volumen
+func (s *store) SetVolumeAttachment(userID, name string, attachment *VolumeAttachment) error {
+	s, end := s.trace("store.SetVolumeAttachment")
+	defer end()
+	collection := s.database.Collection("volumes")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"attachment": ""}}
+	if attachment != nil {
+		update = bson.M{"$set": bson.M{"attachment": attachment}}
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"userId": userID, "name": name}, update); err != nil {
+		return fmt.Errorf("failed to update volume: %w", err)
+	}
+
+	return nil
+}
+
+// DetachVolumes desconecta todos los volúmenes de un contenedor sin borrar sus datos
+func (s *store) DetachVolumes(userID string, containerName ServiceName) error {
+	s, end := s.trace("store.DetachVolumes")
+	defer end()
+	collection := s.database.Collection("volumes")
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"userId": userID, "attachment.containerName": containerName}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"attachment": ""}}); err != nil {
+		return fmt.Errorf("failed to detach volumes: %w", err)
+	}
+
+	return nil
+}
+
+// ContainerMounts devuelve los montajes de los volúmenes conectados al contenedor
+func (s *store) ContainerMounts(userID string, containerName ServiceName) ([]mount.Mount, error) {
+	s, end := s.trace("store.ContainerMounts")
+	defer end()
+	volumes, err := s.GetVolumes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []mount.Mount
+	for _, v := range volumes {
+		if v.Attachment == nil || v.Attachment.ContainerName != containerName {
+			continue
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   v.DockerName,
+			Target:   v.Attachment.MountPath,
+			ReadOnly: v.Attachment.ReadOnly,
+		})
+	}
+
+	return mounts, nil
+}
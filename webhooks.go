@@ -0,0 +1,413 @@
+package main
+
+//Cola persistente de webhooks salientes. Los envíos se guardan en MongoDB antes
+//de intentarse, así que sobreviven a un reinicio de la API y se entregan al menos
+//una vez. Cada envío va firmado con HMAC-SHA256.
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+var (
+	webhookPollInterval = GetEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second)
+	webhookMaxAttempts  = GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	webhookRetention    = GetEnvDuration("WEBHOOK_DELIVERY_RETENTION", 30*24*time.Hour)
+	// Por defecto no se envía nada a direcciones privadas, donde están MongoDB y Docker
+	webhookAllowPrivate = GetEnv("WEBHOOK_ALLOW_PRIVATE", "false") == "true"
+)
+
+const (
+	deliveriesCollection = "webhookDeliveries"
+	// Primer reintento; cada fallo lo duplica hasta webhookMaxBackoff
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// Mientras se envía, el envío queda reservado para que otra pasada no lo tome
+	webhookLease = 2 * time.Minute
+
+	signatureHeader = "X-Plataforma-Signature"
+	timestampHeader = "X-Plataforma-Timestamp"
+	eventHeader     = "X-Plataforma-Event"
+	deliveryHeader  = "X-Plataforma-Delivery"
+)
+
+var errInvalidWebhook = errors.New("invalid webhook")
+
+// webhookWake despierta al worker cuando se encola algo, sin esperar al intervalo
+var webhookWake = make(chan struct{}, 1)
+
+// webhookClient no sigue redirecciones ni se conecta a direcciones privadas
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: otelhttp.NewTransport(&http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: checkWebhookAddr}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// checkWebhookAddr se ejecuta con la IP ya resuelta, así que también cubre los
+// nombres que resuelven a una dirección interna
+func checkWebhookAddr(_, address string, _ syscall.RawConn) error {
+	if webhookAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// isPublicIP descarta loopback, redes privadas, link-local (metadatos de la nube) y
+// direcciones sin especificar
+func isPublicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified()
+}
+
+// ValidateWebhookURL acepta URLs http o https con host
+func ValidateWebhookURL(raw string) error {
+	if len(raw) > 2048 {
+		return fmt.Errorf("%w: url is too long", errInvalidWebhook)
+	}
+	u, err := neturl.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", errInvalidWebhook)
+	}
+	return nil
+}
+
+// newWebhookSecret genera el secreto con el que se firman los envíos
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// signWebhook firma "timestamp.body", para que un envío capturado no se pueda
+// reenviar más tarde con otro timestamp
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookTarget es a dónde y cómo se envía un evento
+type WebhookTarget struct {
+	UserID   string
+	Source   string // alert o webhook
+	SourceID string
+	URL      string
+	Format   string // json o slack
+	Secret   string
+}
+
+// webhookEnvelope es el cuerpo de los envíos en formato json; ID es el mismo en
+// todos los reintentos, para que el receptor descarte duplicados
+type webhookEnvelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// EnqueueWebhook guarda un envío pendiente. text es el mensaje para el formato
+// slack; el formato json envía data completo.
+func (s *store) EnqueueWebhook(target WebhookTarget, event string, data any, text string) error {
+	s, end := s.trace("store.EnqueueWebhook")
+	defer end()
+
+	now := time.Now()
+	d := WebhookDelivery{
+		ID:            primitive.NewObjectID(),
+		UserID:        target.UserID,
+		Source:        target.Source,
+		SourceID:      target.SourceID,
+		Event:         event,
+		URL:           target.URL,
+		Secret:        target.Secret,
+		Status:        "pending",
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	var body any = webhookEnvelope{ID: d.ID.Hex(), Event: event, CreatedAt: now, Data: data}
+	if target.Format == "slack" {
+		body = map[string]string{"text": text}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	d.Payload = string(payload)
+
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+	if _, err := s.database.Collection(deliveriesCollection).InsertOne(ctx, d); err != nil {
+		return fmt.Errorf("failed to queue webhook: %w", err)
+	}
+
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// EnsureWebhookIndexes crea los índices de la cola; el de createdAt borra los
+// envíos más viejos que la retención
+func (s *store) EnsureWebhookIndexes() error {
+	s, end := s.trace("store.EnsureWebhookIndexes")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.database.Collection(deliveriesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "source", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(webhookRetention / time.Second))},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create webhook indexes: %w", err)
+	}
+
+	_, err = s.database.Collection(subscriptionsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "events", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create webhook indexes: %w", err)
+	}
+	return nil
+}
+
+// claimDelivery reserva el próximo envío vencido, o devuelve nil si no hay
+func (s *store) claimDelivery(ctx context.Context) (*WebhookDelivery, error) {
+	now := time.Now()
+	filter := bson.M{"status": "pending", "nextAttemptAt": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(webhookLease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1})
+
+	var d WebhookDelivery
+	err := s.database.Collection(deliveriesCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook delivery: %w", err)
+	}
+	return &d, nil
+}
+
+// deliverySourceExists dice si la regla o la suscripción que generó el envío
+// todavía existe
+func (s *store) deliverySourceExists(ctx context.Context, d *WebhookDelivery) (bool, error) {
+	collection := subscriptionsCollection
+	if d.Source == "alert" {
+		collection = alertsCollection
+	}
+	oid, err := primitive.ObjectIDFromHex(d.SourceID)
+	if err != nil {
+		return false, nil
+	}
+	n, err := s.database.Collection(collection).CountDocuments(ctx, bson.M{"_id": oid, "userId": d.UserID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check webhook source: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CancelDeliveries marca como canceled los envíos pendientes de una regla o
+// suscripción borrada, que guardan su propia copia de la URL y el secreto
+func (s *store) CancelDeliveries(userID, source, sourceID string) error {
+	s, end := s.trace("store.CancelDeliveries")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"userId": userID, "source": source, "sourceId": sourceID, "status": "pending"}
+	update := bson.M{"$set": bson.M{"status": "canceled", "lastError": source + " was deleted"}}
+	if _, err := s.database.Collection(deliveriesCollection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to cancel webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// sendDelivery hace un intento y guarda el resultado: entregado con cualquier 2xx,
+// y si no, reintento con backoff o failed al agotar los intentos
+func (s *store) sendDelivery(ctx context.Context, d *WebhookDelivery) error {
+	s, end := s.trace("store.sendDelivery")
+	defer end()
+
+	// Un envío encolado justo antes de borrar la regla o la suscripción no se manda
+	exists, err := s.deliverySourceExists(ctx, d)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		update := bson.M{"$set": bson.M{"status": "canceled", "lastError": d.Source + " was deleted"}}
+		if _, err := s.database.Collection(deliveriesCollection).UpdateByID(ctx, d.ID, update); err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+		return nil
+	}
+
+	statusCode, err := postWebhook(s.baseContext(), d)
+	d.Attempts++
+	now := time.Now()
+
+	set := bson.M{"attempts": d.Attempts, "lastStatusCode": statusCode}
+	switch {
+	case err == nil:
+		d.Status = "delivered"
+		set["deliveredAt"] = now
+		set["lastError"] = ""
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status = "failed"
+		set["lastError"] = err.Error()
+	default:
+		set["lastError"] = err.Error()
+		set["nextAttemptAt"] = now.Add(webhookBackoff(d.Attempts))
+	}
+	set["status"] = d.Status
+
+	if err != nil {
+		webhookLog.WarnContext(ctx, "webhook delivery failed", "deliveryId", d.ID.Hex(), "event", d.Event,
+			"attempts", d.Attempts, "status", d.Status, "error", err)
+	}
+
+	_, uerr := s.database.Collection(deliveriesCollection).UpdateByID(ctx, d.ID, bson.M{"$set": set})
+	if uerr != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", uerr)
+	}
+	return nil
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff << (attempts - 1)
+	if backoff <= 0 || backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
+
+func postWebhook(ctx context.Context, d *WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "plataforma-webhooks/1")
+	req.Header.Set(eventHeader, d.Event)
+	req.Header.Set(deliveryHeader, d.ID.Hex())
+	req.Header.Set(timestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(signatureHeader, signWebhook(d.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliverPending envía todos los envíos vencidos, uno por uno
+func deliverPending(ctx context.Context, s *store) {
+	s, span := startSpan(s, "webhooks.deliver")
+	defer span.End()
+
+	for ctx.Err() == nil {
+		d, err := s.claimDelivery(ctx)
+		if err != nil {
+			webhookLog.Error("error leyendo la cola", "error", err)
+			return
+		}
+		if d == nil {
+			return
+		}
+		if err := s.sendDelivery(ctx, d); err != nil {
+			webhookLog.Error("error guardando el envío", "error", err)
+		}
+	}
+}
+
+// GetDeliveries devuelve los últimos envíos de un usuario para una fuente; con
+// sourceID solo los de esa regla o suscripción, y con status solo los de ese estado
+func (s *store) GetDeliveries(userID, source, sourceID, status string, limit int64) ([]WebhookDelivery, error) {
+	s, end := s.trace("store.GetDeliveries")
+	defer end()
+	ctx, cancel := context.WithTimeout(s.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"userId": userID, "source": source}
+	if sourceID != "" {
+		filter["sourceId"] = sourceID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(limit)
+
+	cur, err := s.database.Collection(deliveriesCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhook deliveries: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	deliveries := []WebhookDelivery{}
+	if err := cur.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func StartWebhookWorker(ctx context.Context, s *store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deliverPending(ctx, s)
+		case <-webhookWake:
+			deliverPending(ctx, s)
+		case <-ctx.Done():
+			webhookLog.Info("bucle detenido")
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{
+			name:      "body",
+			secret:    "whsec_test",
+			timestamp: 1700000000,
+			body:      `{"event":"ping"}`,
+			want:      "sha256=aa8efe37b751e71157c508c5ac4acb1e9fe5225db98355dfc00f4b680afbc447",
+		},
+		{
+			name:      "empty body",
+			secret:    "whsec_test",
+			timestamp: 1700000000,
+			body:      "",
+			want:      "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Fatalf("signWebhook() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Cambiar el secreto, el timestamp o el cuerpo cambia la firma
+	base := signWebhook("whsec_test", 1700000000, []byte("{}"))
+	for name, sig := range map[string]string{
+		"secret":    signWebhook("whsec_other", 1700000000, []byte("{}")),
+		"timestamp": signWebhook("whsec_test", 1700000001, []byte("{}")),
+		"body":      signWebhook("whsec_test", 1700000000, []byte("{ }")),
+	} {
+		if sig == base {
+			t.Errorf("changing the %s did not change the signature", name)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.17.0.2", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.public {
+				t.Fatalf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+			}
+		})
+	}
+
+	if isPublicIP(nil) {
+		t.Error("isPublicIP(nil) = true, want false")
+	}
+}
+
+func TestCheckWebhookAddr(t *testing.T) {
+	saved := webhookAllowPrivate
+	defer func() { webhookAllowPrivate = saved }()
+
+	tests := []struct {
+		address      string
+		allowPrivate bool
+		valid        bool
+	}{
+		{"93.184.216.34:443", false, true},
+		{"[2606:4700:4700::1111]:443", false, true},
+		{"127.0.0.1:27017", false, false},
+		{"[::1]:2375", false, false},
+		{"172.17.0.1:2375", false, false},
+		{"169.254.169.254:80", false, false},
+		{"127.0.0.1:8080", true, true},
+		{"no-port", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			webhookAllowPrivate = tt.allowPrivate
+			if err := checkWebhookAddr("tcp", tt.address, nil); (err == nil) != tt.valid {
+				t.Fatalf("checkWebhookAddr(%s) = %v, valid = %v", tt.address, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://hooks.example.com/x", true},
+		{"http://example.com:8080/hook?a=1", true},
+		{"ftp://example.com/x", false},
+		{"https://", false},
+		{"/relative/path", false},
+		{"example.com/hook", false},
+		{"", false},
+		{"https://example.com/" + strings.Repeat("a", 2048), false},
+	}
+
+	for _, tt := range tests {
+		name := tt.url
+		if len(name) > 40 {
+			name = name[:40]
+		}
+		t.Run(name, func(t *testing.T) {
+			err := ValidateWebhookURL(tt.url)
+			if (err == nil) != tt.valid {
+				t.Fatalf("ValidateWebhookURL(%q) = %v, valid = %v", tt.url, err, tt.valid)
+			}
+			if err != nil && !errors.Is(err, errInvalidWebhook) {
+				t.Fatalf("ValidateWebhookURL(%q) = %v, want errInvalidWebhook", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, time.Hour},
+		{70, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}